@@ -4,56 +4,139 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/rbalman/cfn-compose/libs"
 	"github.com/rbalman/cfn-compose/logger"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 )
 
+// CFNManager wraps every CloudFormation call made by cfn-compose. Client is
+// usually created with cloudformation.New(session) but any implementation of
+// the CloudFormation API can be injected, e.g. the in-memory fake from the
+// cfntest package.
 type CFNManager struct {
-	Session *session.Session
+	Client cloudformationiface.CloudFormationAPI
+	// Retry is applied to every CloudFormation call, including the waiters
+	Retry RetryPolicy
+	// EventPollInterval is the delay between two polls of the stack events while waiting, defaults to 5s
+	EventPollInterval time.Duration
+	// Region of the client, used to build the console links. Defaults to us-east-1
+	Region string
+	// OnStackEvent is called with the stack events seen while waiting for an operation, in chronological order
+	OnStackEvent func(ctx context.Context, e *cloudformation.StackEvent)
 }
 
+const defaultRegion = "us-east-1"
+
 //Details on CFN Status: https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/using-cfn-describing-stacks.html
 var CfnStatus []string = []string{"CREATE_COMPLETE", "UPDATE_COMPLETE", "ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_FAILED", "ROLLBACK_FAILED", "DELETE_FAILED", "CREATE_IN_PROGRESS", "ROLLBACK_IN_PROGRESS", "DELETE_IN_PROGRESS", "UPDATE_IN_PROGRESS", "UPDATE_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS", "REVIEW_IN_PROGRESS"}
 
 //////// MUTABLE OPERATIONS ////////
-func (cm CFNManager) CreateStack(input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
-	svc := cloudformation.New(cm.Session)
-	return svc.CreateStack(input)
+func (cm CFNManager) CreateStack(ctx context.Context, input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
+	// the same token is sent on every attempt so that a retried request isn't applied twice
+	if input.ClientRequestToken == nil {
+		input.ClientRequestToken = requestToken("create", *input.StackName)
+	}
+
+	var res *cloudformation.CreateStackOutput
+	err := cm.retry(ctx, "CreateStack", func() (err error) {
+		res, err = cm.Client.CreateStack(input)
+		return err
+	})
+	return res, err
 }
 
-func (cm CFNManager) UpdateStack(input *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
-	svc := cloudformation.New(cm.Session)
-	return svc.UpdateStack(input)
+func (cm CFNManager) UpdateStack(ctx context.Context, input *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
+	if input.ClientRequestToken == nil {
+		input.ClientRequestToken = requestToken("update", *input.StackName)
+	}
+
+	var res *cloudformation.UpdateStackOutput
+	err := cm.retry(ctx, "UpdateStack", func() (err error) {
+		res, err = cm.Client.UpdateStack(input)
+		return err
+	})
+	return res, err
 }
 
-func (cm CFNManager) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
-	svc := cloudformation.New(cm.Session)
-	return svc.DeleteStack(input)
+func (cm CFNManager) DeleteStack(ctx context.Context, input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	if input.ClientRequestToken == nil {
+		input.ClientRequestToken = requestToken("delete", *input.StackName)
+	}
+
+	var res *cloudformation.DeleteStackOutput
+	err := cm.retry(ctx, "DeleteStack", func() (err error) {
+		res, err = cm.Client.DeleteStack(input)
+		return err
+	})
+	return res, err
 }
 
-func (cm CFNManager) CreateChangeSet(input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
-	svc := cloudformation.New(cm.Session)
-	return svc.CreateChangeSet(input)
+func (cm CFNManager) CreateChangeSet(ctx context.Context, input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
+	var res *cloudformation.CreateChangeSetOutput
+	err := cm.retry(ctx, "CreateChangeSet", func() (err error) {
+		res, err = cm.Client.CreateChangeSet(input)
+		return err
+	})
+	return res, err
+}
+
+func (cm CFNManager) ContinueUpdateRollback(ctx context.Context, input *cloudformation.ContinueUpdateRollbackInput) (*cloudformation.ContinueUpdateRollbackOutput, error) {
+	if input.ClientRequestToken == nil {
+		input.ClientRequestToken = requestToken("continue", *input.StackName)
+	}
+
+	var res *cloudformation.ContinueUpdateRollbackOutput
+	err := cm.retry(ctx, "ContinueUpdateRollback", func() (err error) {
+		res, err = cm.Client.ContinueUpdateRollback(input)
+		return err
+	})
+	return res, err
+}
+
+func (cm CFNManager) CancelUpdateStack(ctx context.Context, stackName string) (*cloudformation.CancelUpdateStackOutput, error) {
+	input := &cloudformation.CancelUpdateStackInput{
+		ClientRequestToken: requestToken("cancel", stackName),
+		StackName:          aws.String(stackName),
+	}
+
+	var res *cloudformation.CancelUpdateStackOutput
+	err := cm.retry(ctx, "CancelUpdateStack", func() (err error) {
+		res, err = cm.Client.CancelUpdateStack(input)
+		return err
+	})
+	return res, err
+}
+
+func (cm CFNManager) DeleteChangeSet(ctx context.Context, stackName string, changeSetName string) (*cloudformation.DeleteChangeSetOutput, error) {
+	input := &cloudformation.DeleteChangeSetInput{
+		ChangeSetName: aws.String(changeSetName),
+		StackName:     aws.String(stackName),
+	}
+
+	var res *cloudformation.DeleteChangeSetOutput
+	err := cm.retry(ctx, "DeleteChangeSet", func() (err error) {
+		res, err = cm.Client.DeleteChangeSet(input)
+		return err
+	})
+	return res, err
 }
 
 func (cm CFNManager) ExecuteChangeSet(ctx context.Context, input *cloudformation.ExecuteChangeSetInput) (*cloudformation.ExecuteChangeSetOutput, error) {
-	svc := cloudformation.New(cm.Session)
-	res, err := svc.ExecuteChangeSet(input)
+	if input.ClientRequestToken == nil {
+		input.ClientRequestToken = requestToken("execute", *input.StackName)
+	}
+
+	var res *cloudformation.ExecuteChangeSetOutput
+	err := cm.retry(ctx, "ExecuteChangeSet", func() (err error) {
+		res, err = cm.Client.ExecuteChangeSet(input)
+		return err
+	})
+	// e.g. InvalidChangeSetStatus when the change set is obsolete, nothing was deployed
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case "InvalidChangeSetStatus":
-				logger.Log.WarnCtxf(ctx, "Change-set couldn't be applied. Warning: %s\n", err.Error())
-			default:
-				return res, err
-			}
-		}
+		return res, fmt.Errorf("Change-set couldn't be executed, ERROR: %w", err)
 	}
 
 	return res, nil
@@ -62,17 +145,16 @@ func (cm CFNManager) ExecuteChangeSet(ctx context.Context, input *cloudformation
 
 //////// MUTABLE WAIT OPERATIONS ////////
 func (cm CFNManager) CreateStackWithWait(ctx context.Context, input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
-	res, err := cm.CreateStack(input)
+	res, err := cm.CreateStack(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	ch := make(chan bool)
-	go libs.Loader(ctx, ch)
-	err = cm.WaitStackCreateComplete(*input.StackName)
-	ch <- true
+	stop := cm.watchStackEvents(ctx, aws.StringValue(res.StackId), "")
+	err = cm.WaitStackCreateComplete(ctx, *input.StackName)
+	stop()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Wait CreateStack failed, ERROR: %s", err.Error()))
+		return nil, cm.waitError(ctx, "CreateStack", *input.StackName, aws.StringValue(res.StackId), "", err)
 	}
 
 	logger.Log.InfoCtxf(ctx, "Create Complete...")
@@ -80,17 +162,17 @@ func (cm CFNManager) CreateStackWithWait(ctx context.Context, input *cloudformat
 }
 
 func (cm CFNManager) UpdateStackWithWait(ctx context.Context, input *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
-	res, err := cm.UpdateStack(input)
+	last := cm.LatestStackEvent(ctx, *input.StackName)
+	res, err := cm.UpdateStack(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	ch := make(chan bool)
-	go libs.Loader(ctx, ch)
-	err = cm.WaitStackUpdateComplete(*input.StackName)
-	ch <- true
+	stop := cm.watchStackEvents(ctx, aws.StringValue(res.StackId), eventID(last))
+	err = cm.WaitStackUpdateComplete(ctx, *input.StackName)
+	stop()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Wait UpdateStack failed, ERROR: %s", err.Error()))
+		return nil, cm.waitError(ctx, "UpdateStack", *input.StackName, aws.StringValue(res.StackId), eventID(last), err)
 	}
 
 	logger.Log.InfoCtxf(ctx, "Update Completed.")
@@ -98,17 +180,20 @@ func (cm CFNManager) UpdateStackWithWait(ctx context.Context, input *cloudformat
 }
 
 func (cm CFNManager) DeleteStackWithWait(ctx context.Context, input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
-	res, err := cm.DeleteStack(input)
+	last := cm.LatestStackEvent(ctx, *input.StackName)
+	res, err := cm.DeleteStack(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	ch := make(chan bool)
-	go libs.Loader(ctx, ch)
-	err = cm.WaitStackDeleteComplete(*input.StackName)
-	ch <- true
+	stop := func() {}
+	if last != nil {
+		stop = cm.watchStackEvents(ctx, aws.StringValue(last.StackId), eventID(last))
+	}
+	err = cm.WaitStackDeleteComplete(ctx, *input.StackName)
+	stop()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Wait DeleteStack failed, ERROR: %s", err.Error()))
+		return nil, cm.waitError(ctx, "DeleteStack", *input.StackName, stackID(last), eventID(last), err)
 	}
 
 	logger.Log.InfoCtxf(ctx, "Delete Complete...")
@@ -116,16 +201,16 @@ func (cm CFNManager) DeleteStackWithWait(ctx context.Context, input *cloudformat
 }
 
 func (cm CFNManager) CreateChangeSetWithWait(ctx context.Context, input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
-	res, err := cm.CreateChangeSet(input)
+	res, err := cm.CreateChangeSet(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	ch := make(chan bool)
-	go libs.Loader(ctx, ch)
-	err = cm.WaitChangeSetCreateComplete(*input.StackName, *input.ChangeSetName)
-	ch <- true
+	err = cm.WaitChangeSetCreateComplete(ctx, *input.StackName, *input.ChangeSetName)
 	if err != nil {
+		if terr := cm.timeoutError(ctx, "CreateChangeSet", *input.StackName); terr != nil {
+			return nil, terr
+		}
 		return nil, errors.New(fmt.Sprintf("Change-set create failed, ERROR: %s", err.Error()))
 	}
 
@@ -133,83 +218,122 @@ func (cm CFNManager) CreateChangeSetWithWait(ctx context.Context, input *cloudfo
 }
 
 func (cm CFNManager) ExecuteChangeSetWithWait(ctx context.Context, input *cloudformation.ExecuteChangeSetInput) (*cloudformation.ExecuteChangeSetOutput, error) {
+	// the change sets of a stack in REVIEW_IN_PROGRESS state create the stack
+	operation, wait := "UpdateStack", cm.WaitStackUpdateComplete
+	if res, err := cm.DescribeStacks(ctx, *input.StackName); err == nil && len(res.Stacks) > 0 && aws.StringValue(res.Stacks[0].StackStatus) == "REVIEW_IN_PROGRESS" {
+		operation, wait = "CreateStack", cm.WaitStackCreateComplete
+	}
+
+	last := cm.LatestStackEvent(ctx, *input.StackName)
 	res, err := cm.ExecuteChangeSet(ctx, input)
 	if err != nil {
 		return nil, err
 	}
 
-	time.Sleep(5 * time.Second)
-	ch := make(chan bool)
-	go libs.Loader(ctx, ch)
-	err = cm.WaitStackUpdateComplete(*input.StackName)
-	ch <- true
+	select {
+	case <-time.After(5 * time.Second):
+	case <-ctx.Done():
+	}
+	stop := func() {}
+	if last != nil {
+		stop = cm.watchStackEvents(ctx, aws.StringValue(last.StackId), eventID(last))
+	}
+	err = wait(ctx, *input.StackName)
+	stop()
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("Wait UpdateStack failed, ERROR: %s", err.Error()))
+		return nil, cm.waitError(ctx, operation, *input.StackName, stackID(last), eventID(last), err)
 	}
 
 	return res, nil
 }
 
-//////// WAIT OPERATIONS ////////
-func (cm CFNManager) WaitStackCreateComplete(stackName string) error {
-	svc := cloudformation.New(cm.Session)
+func (cm CFNManager) ContinueUpdateRollbackWithWait(ctx context.Context, input *cloudformation.ContinueUpdateRollbackInput) error {
+	last := cm.LatestStackEvent(ctx, *input.StackName)
+	_, err := cm.ContinueUpdateRollback(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	stop := func() {}
+	if last != nil {
+		stop = cm.watchStackEvents(ctx, stackID(last), eventID(last))
+	}
+	status, err := cm.waitStackStable(ctx, *input.StackName)
+	stop()
+	if err != nil {
+		if terr := cm.timeoutError(ctx, "ContinueUpdateRollback", *input.StackName); terr != nil {
+			return terr
+		}
+		return err
+	}
 
+	if status != "UPDATE_ROLLBACK_COMPLETE" {
+		return cm.stackError(ctx, "ContinueUpdateRollback", *input.StackName, stackID(last), eventID(last), fmt.Errorf("stack is in %s state", status))
+	}
+
+	logger.Log.InfoCtxf(ctx, "Update Rollback Completed.")
+	return nil
+}
+
+//////// WAIT OPERATIONS ////////
+func (cm CFNManager) WaitStackCreateComplete(ctx context.Context, stackName string) error {
 	input := cloudformation.DescribeStacksInput{
 		StackName: &stackName,
 	}
 
 	// stack delete output is an empty struct
-	err := svc.WaitUntilStackCreateComplete(&input)
-	return err
+	return cm.retry(ctx, "WaitUntilStackCreateComplete", func() error {
+		return cm.Client.WaitUntilStackCreateCompleteWithContext(ctx, &input)
+	})
 }
 
-func (cm CFNManager) WaitChangeSetCreateComplete(stackName string, changesetName string) error {
-	svc := cloudformation.New(cm.Session)
-
+func (cm CFNManager) WaitChangeSetCreateComplete(ctx context.Context, stackName string, changesetName string) error {
 	input := cloudformation.DescribeChangeSetInput{
 		ChangeSetName: &changesetName,
 		StackName:     &stackName,
 	}
 
-	err := svc.WaitUntilChangeSetCreateComplete(&input)
-	return err
+	return cm.retry(ctx, "WaitUntilChangeSetCreateComplete", func() error {
+		return cm.Client.WaitUntilChangeSetCreateCompleteWithContext(ctx, &input)
+	})
 }
 
-func (cm CFNManager) WaitStackUpdateComplete(stackName string) error {
-	svc := cloudformation.New(cm.Session)
-
+func (cm CFNManager) WaitStackUpdateComplete(ctx context.Context, stackName string) error {
 	input := cloudformation.DescribeStacksInput{
 		StackName: &stackName,
 	}
 
-	err := svc.WaitUntilStackUpdateComplete(&input)
-	return err
+	return cm.retry(ctx, "WaitUntilStackUpdateComplete", func() error {
+		return cm.Client.WaitUntilStackUpdateCompleteWithContext(ctx, &input)
+	})
 }
 
-func (cm CFNManager) WaitStackDeleteComplete(stackName string) error {
-	svc := cloudformation.New(cm.Session)
-
+func (cm CFNManager) WaitStackDeleteComplete(ctx context.Context, stackName string) error {
 	input := cloudformation.DescribeStacksInput{
 		StackName: &stackName,
 	}
 
 	// stack delete output is an empty struct
-	err := svc.WaitUntilStackDeleteComplete(&input)
-	return err
+	return cm.retry(ctx, "WaitUntilStackDeleteComplete", func() error {
+		return cm.Client.WaitUntilStackDeleteCompleteWithContext(ctx, &input)
+	})
 }
 
 //////// READ OPERATIONS ////////
-func (cm CFNManager) DescribeStacks(stackName string) (*cloudformation.DescribeStacksOutput, error) {
+func (cm CFNManager) DescribeStacks(ctx context.Context, stackName string) (*cloudformation.DescribeStacksOutput, error) {
 	input := &cloudformation.DescribeStacksInput{
 		StackName: aws.String(stackName),
 	}
 
-	svc := cloudformation.New(cm.Session)
-	return svc.DescribeStacks(input)
+	var res *cloudformation.DescribeStacksOutput
+	err := cm.retry(ctx, "DescribeStacks", func() (err error) {
+		res, err = cm.Client.DescribeStacks(input)
+		return err
+	})
+	return res, err
 }
 
-func (cm CFNManager) ListStacks() (*cloudformation.ListStacksOutput, error) {
-	svc := cloudformation.New(cm.Session)
+func (cm CFNManager) ListStacks(ctx context.Context) (*cloudformation.ListStacksOutput, error) {
 	var pstatus []*string
 
 	for _, s := range CfnStatus {
@@ -221,16 +345,34 @@ func (cm CFNManager) ListStacks() (*cloudformation.ListStacksOutput, error) {
 	input := &cloudformation.ListStacksInput{
 		StackStatusFilter: pstatus,
 	}
-	return svc.ListStacks(input)
+	var res *cloudformation.ListStacksOutput
+	err := cm.retry(ctx, "ListStacks", func() (err error) {
+		res, err = cm.Client.ListStacks(input)
+		return err
+	})
+	return res, err
 }
 
-func (cm CFNManager) DescribeChangeSet(stackName string, changeSetName string) (*cloudformation.DescribeChangeSetOutput, error) {
-	svc := cloudformation.New(cm.Session)
-
+func (cm CFNManager) DescribeChangeSet(ctx context.Context, stackName string, changeSetName string) (*cloudformation.DescribeChangeSetOutput, error) {
 	input := cloudformation.DescribeChangeSetInput{
 		ChangeSetName: aws.String(changeSetName),
 		StackName:     &stackName,
 	}
 
-	return svc.DescribeChangeSet(&input)
+	var res *cloudformation.DescribeChangeSetOutput
+	err := cm.retry(ctx, "DescribeChangeSet", func() (err error) {
+		res, err = cm.Client.DescribeChangeSet(&input)
+		return err
+	})
+	return res, err
+}
+
+// requestToken returns a unique ClientRequestToken, it also identifies the operations started by cfn-compose in the stack events
+func requestToken(operation string, stackName string) *string {
+	prefix := fmt.Sprintf("cfnc-%s-%s", operation, stackName)
+	suffix := fmt.Sprintf("-%d", time.Now().UnixNano())
+	if len(prefix)+len(suffix) > 128 {
+		prefix = prefix[:128-len(suffix)]
+	}
+	return aws.String(prefix + suffix)
 }
@@ -0,0 +1,1000 @@
+/*
+Package cfntest provides an in-memory CloudFormation backend so that stack
+and compose operations can be exercised without an AWS account.
+
+The fake models the stack status life cycle: every mutating call moves the
+stack into the matching *_IN_PROGRESS status and every DescribeStacks call
+advances it one step further, while the waiters settle the stack straight
+into its terminal status. Failures can be injected per stack with Fail.
+*/
+package cfntest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+)
+
+const (
+	accountID = "123456789012"
+	region    = "us-east-1"
+)
+
+type failure struct {
+	logicalID string
+	reason    string
+	status    string
+	// nested is the logical id of the nested stack holding the failed resource
+	nested string
+}
+
+type stack struct {
+	id           string
+	name         string
+	status       string
+	pending      []string
+	token        string
+	templateBody string
+	templateURL  string
+	parameters   []*cloudformation.Parameter
+	capabilities []*string
+	tags         []*cloudformation.Tag
+	timeout      *int64
+	outputs      map[string]string
+	events       []*cloudformation.StackEvent
+	failure      *failure
+	created      time.Time
+	updated      *time.Time
+}
+
+type changeSet struct {
+	id            string
+	name          string
+	stackName     string
+	changeSetType string
+	status        string
+	reason        string
+	execStatus    string
+	input         *cloudformation.CreateChangeSetInput
+	changes       []*cloudformation.Change
+	created       time.Time
+}
+
+// FakeCloudFormation implements cloudformationiface.CloudFormationAPI in
+// memory. Only the operations used by cfn-compose are implemented, calling
+// any other operation panics.
+type FakeCloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+
+	mu         sync.Mutex
+	seq        int
+	clock      time.Time
+	stacks     map[string]*stack
+	deleted    map[string]*stack
+	changeSets map[string]*changeSet
+	failures   map[string]failure
+	changes    map[string][]*cloudformation.Change
+	csFailures map[string]string
+	rejections map[string]string
+	outputs    map[string]map[string]string
+	latency    map[string]time.Duration
+	throttles  map[string]int
+	calls      []string
+}
+
+func NewFakeCloudFormation() *FakeCloudFormation {
+	return &FakeCloudFormation{
+		stacks:     make(map[string]*stack),
+		deleted:    make(map[string]*stack),
+		changeSets: make(map[string]*changeSet),
+		failures:   make(map[string]failure),
+		changes:    make(map[string][]*cloudformation.Change),
+		csFailures: make(map[string]string),
+		rejections: make(map[string]string),
+		outputs:    make(map[string]map[string]string),
+		latency:    make(map[string]time.Duration),
+		throttles:  make(map[string]int),
+	}
+}
+
+//////// TEST HELPERS ////////
+
+// PutStack seeds a stack with the given status, as if it had been created
+// outside of cfn-compose.
+func (f *FakeCloudFormation) PutStack(name string, status string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.newStack(name)
+	s.status = status
+	f.addEvent(s, name, "AWS::CloudFormation::Stack", status, "")
+
+	// an operation in progress completes after a few polls
+	if completed := completion(status); completed != "" {
+		s.pending = []string{completed}
+	}
+}
+
+// StartOperation seeds a stack with an operation in progress started outside
+// of cfn-compose with the given ClientRequestToken. The operation completes
+// after the given number of DescribeStacks calls.
+func (f *FakeCloudFormation) StartOperation(name, status, token string, polls int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s := f.newStack(name)
+	s.token = token
+	s.status = status
+	f.addEvent(s, name, "AWS::CloudFormation::Stack", status, "User Initiated")
+
+	for i := 1; i < polls; i++ {
+		s.pending = append(s.pending, status)
+	}
+	s.pending = append(s.pending, completion(status))
+}
+
+// completion returns the status reached once the in progress status
+// completes successfully, empty when the status isn't in progress.
+func completion(status string) string {
+	switch {
+	case strings.HasSuffix(status, "_COMPLETE_CLEANUP_IN_PROGRESS"):
+		return strings.TrimSuffix(status, "_CLEANUP_IN_PROGRESS")
+	case strings.HasSuffix(status, "_IN_PROGRESS") && status != "REVIEW_IN_PROGRESS":
+		return strings.TrimSuffix(status, "_IN_PROGRESS") + "_COMPLETE"
+	}
+	return ""
+}
+
+// Fail makes the next create, update, delete or change set execution of the
+// stack fail on the given logical resource with the given reason.
+func (f *FakeCloudFormation) Fail(stackName, logicalID, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[stackName] = failure{logicalID: logicalID, reason: reason}
+}
+
+// FailNested makes the next operation of the stack fail on a resource of the
+// nested stack created by the nestedLogicalID resource.
+func (f *FakeCloudFormation) FailNested(stackName, nestedLogicalID, logicalID, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[stackName] = failure{logicalID: logicalID, reason: reason, nested: nestedLogicalID}
+}
+
+// SetLatency makes the stack waiters block for the given duration before the
+// stack settles, simulating slow resources. Meanwhile DescribeStacks doesn't
+// move the stack forward.
+func (f *FakeCloudFormation) SetLatency(stackName string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency[stackName] = d
+}
+
+// Throttle makes the next count calls of the operation, e.g. "CreateStack",
+// fail with a Throttling error.
+func (f *FakeCloudFormation) Throttle(operation string, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.throttles[operation] = count
+}
+
+// SetChanges defines the resource changes reported by the next change sets
+// created for the stack.
+func (f *FakeCloudFormation) SetChanges(stackName string, changes []*cloudformation.Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.changes[stackName] = changes
+}
+
+// FailChangeSet makes the next change set created for the stack fail with
+// the given status reason, e.g. a transform or IAM error.
+func (f *FakeCloudFormation) FailChangeSet(stackName, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.csFailures[stackName] = reason
+}
+
+// RejectUpdate makes the next update of the stack fail with a ValidationError
+// of the given message, e.g. a template format error.
+func (f *FakeCloudFormation) RejectUpdate(stackName, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejections[stackName] = message
+}
+
+// SetOutputs defines the outputs exposed by the stack once it reaches a
+// complete status.
+func (f *FakeCloudFormation) SetOutputs(stackName string, outputs map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.outputs[stackName] = outputs
+	if s, ok := f.stacks[stackName]; ok {
+		s.outputs = outputs
+	}
+}
+
+// StackStatus returns the current status of the stack, "DOESN'T EXIST" when
+// the stack was never created or has been deleted.
+func (f *FakeCloudFormation) StackStatus(name string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, ok := f.stacks[name]
+	if !ok {
+		return "DOESN'T EXIST"
+	}
+	return s.status
+}
+
+// StackInput returns the template body and parameters last submitted for the
+// stack.
+func (f *FakeCloudFormation) StackInput(name string) (string, map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	params := make(map[string]string)
+	s, ok := f.stacks[name]
+	if !ok {
+		return "", params
+	}
+	for _, p := range s.parameters {
+		params[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+	}
+	return s.templateBody, params
+}
+
+// Calls returns the mutating calls received so far in "Operation:StackName"
+// format.
+func (f *FakeCloudFormation) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.calls...)
+}
+
+//////// INTERNALS ////////
+
+func (f *FakeCloudFormation) now() time.Time {
+	now := time.Now()
+	if !now.After(f.clock) {
+		now = f.clock.Add(time.Millisecond)
+	}
+	f.clock = now
+	return now
+}
+
+func (f *FakeCloudFormation) newStack(name string) *stack {
+	f.seq++
+	s := &stack{
+		id:      fmt.Sprintf("arn:aws:cloudformation:%s:%s:stack/%s/%d", region, accountID, name, f.seq),
+		name:    name,
+		outputs: f.outputs[name],
+		created: f.now(),
+	}
+	f.stacks[name] = s
+	return s
+}
+
+func (f *FakeCloudFormation) record(op, stackName string) {
+	f.calls = append(f.calls, op+":"+stackName)
+}
+
+func (f *FakeCloudFormation) throttled(operation string) error {
+	if f.throttles[operation] <= 0 {
+		return nil
+	}
+	f.throttles[operation]--
+	return awserr.NewRequestFailure(awserr.New("Throttling", "Rate exceeded", nil), 400, "")
+}
+
+func (f *FakeCloudFormation) addEvent(s *stack, logicalID, resourceType, status, reason string) {
+	f.seq++
+	event := &cloudformation.StackEvent{
+		EventId:           aws.String(fmt.Sprintf("%s-%d", logicalID, f.seq)),
+		StackId:           aws.String(s.id),
+		StackName:         aws.String(s.name),
+		LogicalResourceId: aws.String(logicalID),
+		ResourceType:      aws.String(resourceType),
+		ResourceStatus:    aws.String(status),
+		Timestamp:         aws.Time(f.now()),
+	}
+	if logicalID == s.name {
+		event.PhysicalResourceId = aws.String(s.id)
+	}
+	if s.token != "" {
+		event.ClientRequestToken = aws.String(s.token)
+	}
+	if reason != "" {
+		event.ResourceStatusReason = aws.String(reason)
+	}
+	s.events = append(s.events, event)
+}
+
+// transition moves the stack into the in progress status and queues the
+// statuses it goes through until it settles. The events of the operation
+// carry its ClientRequestToken.
+func (f *FakeCloudFormation) transition(s *stack, token *string, inProgress string, pending ...string) {
+	s.token = aws.StringValue(token)
+	s.status = inProgress
+	s.pending = pending
+	f.addEvent(s, s.name, "AWS::CloudFormation::Stack", inProgress, "User Initiated")
+	if s.failure != nil {
+		f.addFailureEvents(s, *s.failure)
+		s.failure = nil
+	}
+}
+
+// advance moves the stack one status forward.
+func (f *FakeCloudFormation) advance(s *stack) {
+	if len(s.pending) == 0 {
+		return
+	}
+	s.status = s.pending[0]
+	s.pending = s.pending[1:]
+	f.addEvent(s, s.name, "AWS::CloudFormation::Stack", s.status, "")
+	if s.status == "DELETE_COMPLETE" {
+		delete(f.stacks, s.name)
+		f.deleted[s.id] = s
+	}
+}
+
+func (f *FakeCloudFormation) settle(s *stack) {
+	for len(s.pending) > 0 {
+		f.advance(s)
+	}
+}
+
+func (f *FakeCloudFormation) addFailureEvents(s *stack, fl failure) {
+	if fl.nested == "" {
+		f.addEvent(s, fl.logicalID, "AWS::CloudFormation::CustomResource", fl.status, fl.reason)
+		return
+	}
+
+	f.seq++
+	nested := &stack{
+		id:      fmt.Sprintf("arn:aws:cloudformation:%s:%s:stack/%s-%s-%d/%d", region, accountID, s.name, fl.nested, f.seq, f.seq),
+		name:    fmt.Sprintf("%s-%s-%d", s.name, fl.nested, f.seq),
+		created: f.now(),
+	}
+	f.deleted[nested.id] = nested
+	f.addEvent(nested, nested.name, "AWS::CloudFormation::Stack", "CREATE_IN_PROGRESS", "User Initiated")
+	f.addEvent(nested, fl.logicalID, "AWS::CloudFormation::CustomResource", fl.status, fl.reason)
+	f.addEvent(nested, nested.name, "AWS::CloudFormation::Stack", "ROLLBACK_COMPLETE", "")
+
+	f.addEvent(s, fl.nested, "AWS::CloudFormation::Stack", fl.status, fmt.Sprintf("Embedded stack %s was not successfully created: The following resource(s) failed to create: [%s].", nested.id, fl.logicalID))
+	event := s.events[len(s.events)-1]
+	event.PhysicalResourceId = aws.String(nested.id)
+}
+
+// takeFailure reports whether a failure was injected for the stack, the
+// failed resource event is recorded by the next transition.
+func (f *FakeCloudFormation) takeFailure(s *stack, status string) bool {
+	fl, ok := f.failures[s.name]
+	if !ok {
+		return false
+	}
+	delete(f.failures, s.name)
+	fl.status = status
+	s.failure = &fl
+	return true
+}
+
+func (f *FakeCloudFormation) lookup(name string) (*stack, error) {
+	for _, s := range f.stacks {
+		if s.name == name || s.id == name {
+			return s, nil
+		}
+	}
+	return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", name), nil)
+}
+
+func (f *FakeCloudFormation) lookupChangeSet(stackName, name string) (*changeSet, error) {
+	if cs, ok := f.changeSets[name]; ok {
+		return cs, nil
+	}
+	for _, cs := range f.changeSets {
+		if cs.name == name && cs.stackName == stackName {
+			return cs, nil
+		}
+	}
+	return nil, awserr.New("ChangeSetNotFound", fmt.Sprintf("ChangeSet [%s] does not exist", name), nil)
+}
+
+func (f *FakeCloudFormation) describe(s *stack) *cloudformation.Stack {
+	cs := &cloudformation.Stack{
+		StackId:          aws.String(s.id),
+		StackName:        aws.String(s.name),
+		StackStatus:      aws.String(s.status),
+		CreationTime:     aws.Time(s.created),
+		LastUpdatedTime:  s.updated,
+		Parameters:       s.parameters,
+		Capabilities:     s.capabilities,
+		Tags:             s.tags,
+		TimeoutInMinutes: s.timeout,
+	}
+
+	keys := make([]string, 0, len(s.outputs))
+	for k := range s.outputs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cs.Outputs = append(cs.Outputs, &cloudformation.Output{OutputKey: aws.String(k), OutputValue: aws.String(s.outputs[k])})
+	}
+	return cs
+}
+
+func sameParameters(a, b []*cloudformation.Parameter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	m := make(map[string]string)
+	for _, p := range a {
+		m[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+	}
+	for _, p := range b {
+		if aws.BoolValue(p.UsePreviousValue) {
+			continue
+		}
+		if v, ok := m[aws.StringValue(p.ParameterKey)]; !ok || v != aws.StringValue(p.ParameterValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// previousValues returns the parameters where the ones set with UsePreviousValue get their current value
+func previousValues(current, parameters []*cloudformation.Parameter) []*cloudformation.Parameter {
+	values := make(map[string]string)
+	for _, p := range current {
+		values[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+	}
+
+	var resolved []*cloudformation.Parameter
+	for _, p := range parameters {
+		if aws.BoolValue(p.UsePreviousValue) {
+			p = &cloudformation.Parameter{ParameterKey: p.ParameterKey, ParameterValue: aws.String(values[aws.StringValue(p.ParameterKey)])}
+		}
+		resolved = append(resolved, p)
+	}
+	return resolved
+}
+
+// usePreviousValueError is returned when a new stack is given parameters set with UsePreviousValue
+func usePreviousValueError(parameters []*cloudformation.Parameter) error {
+	for _, p := range parameters {
+		if aws.BoolValue(p.UsePreviousValue) {
+			return awserr.New("ValidationError", fmt.Sprintf("Invalid input for parameter key %s. Cannot specify usePreviousValue as true for a parameter key not in the previous template", aws.StringValue(p.ParameterKey)), nil)
+		}
+	}
+	return nil
+}
+
+func waiterError() error {
+	return awserr.New(request.WaiterResourceNotReadyErrorCode, "failed waiting for successful resource state", nil)
+}
+
+//////// MUTABLE OPERATIONS ////////
+
+func (f *FakeCloudFormation) CreateStack(input *cloudformation.CreateStackInput) (*cloudformation.CreateStackOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("CreateStack"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.StackName)
+	f.record("CreateStack", name)
+	if _, err := f.lookup(name); err == nil {
+		return nil, awserr.New("AlreadyExistsException", fmt.Sprintf("Stack [%s] already exists", name), nil)
+	}
+	if err := usePreviousValueError(input.Parameters); err != nil {
+		return nil, err
+	}
+
+	s := f.newStack(name)
+	s.templateBody = aws.StringValue(input.TemplateBody)
+	s.templateURL = aws.StringValue(input.TemplateURL)
+	s.parameters = input.Parameters
+	s.capabilities = input.Capabilities
+	s.tags = input.Tags
+	s.timeout = input.TimeoutInMinutes
+
+	if f.takeFailure(s, "CREATE_FAILED") {
+		f.transition(s, input.ClientRequestToken, "CREATE_IN_PROGRESS", "ROLLBACK_IN_PROGRESS", "ROLLBACK_COMPLETE")
+	} else {
+		f.transition(s, input.ClientRequestToken, "CREATE_IN_PROGRESS", "CREATE_COMPLETE")
+	}
+
+	return &cloudformation.CreateStackOutput{StackId: aws.String(s.id)}, nil
+}
+
+func (f *FakeCloudFormation) UpdateStack(input *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("UpdateStack"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.StackName)
+	f.record("UpdateStack", name)
+	s, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if message, ok := f.rejections[name]; ok {
+		delete(f.rejections, name)
+		return nil, awserr.New("ValidationError", message, nil)
+	}
+
+	switch s.status {
+	case "CREATE_COMPLETE", "UPDATE_COMPLETE", "UPDATE_ROLLBACK_COMPLETE":
+	default:
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack:%s is in %s state and can not be updated.", s.id, s.status), nil)
+	}
+
+	body := aws.StringValue(input.TemplateBody)
+	if aws.BoolValue(input.UsePreviousTemplate) {
+		body = s.templateBody
+	}
+	if body == s.templateBody && aws.StringValue(input.TemplateURL) == s.templateURL && sameParameters(s.parameters, input.Parameters) {
+		return nil, awserr.New("ValidationError", "No updates are to be performed.", nil)
+	}
+
+	s.templateBody = body
+	s.templateURL = aws.StringValue(input.TemplateURL)
+	s.parameters = previousValues(s.parameters, input.Parameters)
+	s.capabilities = input.Capabilities
+	s.tags = input.Tags
+	s.updated = aws.Time(f.now())
+
+	if f.takeFailure(s, "UPDATE_FAILED") {
+		f.transition(s, input.ClientRequestToken, "UPDATE_IN_PROGRESS", "UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE")
+	} else {
+		f.transition(s, input.ClientRequestToken, "UPDATE_IN_PROGRESS", "UPDATE_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_COMPLETE")
+	}
+
+	return &cloudformation.UpdateStackOutput{StackId: aws.String(s.id)}, nil
+}
+
+func (f *FakeCloudFormation) DeleteStack(input *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("DeleteStack"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.StackName)
+	f.record("DeleteStack", name)
+	s, err := f.lookup(name)
+	if err != nil {
+		// deleting a stack that doesn't exist is a no-op in CloudFormation
+		return &cloudformation.DeleteStackOutput{}, nil
+	}
+
+	if f.takeFailure(s, "DELETE_FAILED") {
+		f.transition(s, input.ClientRequestToken, "DELETE_IN_PROGRESS", "DELETE_FAILED")
+	} else {
+		f.transition(s, input.ClientRequestToken, "DELETE_IN_PROGRESS", "DELETE_COMPLETE")
+	}
+
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func (f *FakeCloudFormation) CreateChangeSet(input *cloudformation.CreateChangeSetInput) (*cloudformation.CreateChangeSetOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("CreateChangeSet"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.StackName)
+	f.record("CreateChangeSet", name)
+
+	changeSetType := aws.StringValue(input.ChangeSetType)
+	if changeSetType == "" {
+		changeSetType = "UPDATE"
+	}
+
+	if changeSetType == "CREATE" {
+		if err := usePreviousValueError(input.Parameters); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := f.lookup(name)
+	switch {
+	case changeSetType == "CREATE" && err == nil && s.status != "REVIEW_IN_PROGRESS":
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack [%s] already exists and cannot be created again with the changeSet [%s].", name, aws.StringValue(input.ChangeSetName)), nil)
+	case changeSetType == "CREATE" && err != nil:
+		s = f.newStack(name)
+		s.status = "REVIEW_IN_PROGRESS"
+		f.addEvent(s, name, "AWS::CloudFormation::Stack", s.status, "User Initiated")
+	case err != nil:
+		return nil, err
+	}
+
+	f.seq++
+	cs := &changeSet{
+		id:            fmt.Sprintf("arn:aws:cloudformation:%s:%s:changeSet/%s/%d", region, accountID, aws.StringValue(input.ChangeSetName), f.seq),
+		name:          aws.StringValue(input.ChangeSetName),
+		stackName:     name,
+		changeSetType: changeSetType,
+		status:        "CREATE_COMPLETE",
+		execStatus:    "AVAILABLE",
+		input:         input,
+		changes:       f.changes[name],
+		created:       f.now(),
+	}
+
+	if changeSetType == "UPDATE" && aws.StringValue(input.TemplateBody) == s.templateBody && aws.StringValue(input.TemplateURL) == s.templateURL && sameParameters(s.parameters, input.Parameters) {
+		cs.status = "FAILED"
+		cs.execStatus = "UNAVAILABLE"
+		cs.reason = "The submitted information didn't contain changes. Submit different information to create a change set."
+		cs.changes = nil
+	}
+	if reason, ok := f.csFailures[name]; ok {
+		delete(f.csFailures, name)
+		cs.status = "FAILED"
+		cs.execStatus = "UNAVAILABLE"
+		cs.reason = reason
+		cs.changes = nil
+	}
+	f.changeSets[cs.id] = cs
+
+	return &cloudformation.CreateChangeSetOutput{Id: aws.String(cs.id), StackId: aws.String(s.id)}, nil
+}
+
+func (f *FakeCloudFormation) ExecuteChangeSet(input *cloudformation.ExecuteChangeSetInput) (*cloudformation.ExecuteChangeSetOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("ExecuteChangeSet"); err != nil {
+		return nil, err
+	}
+
+	cs, err := f.lookupChangeSet(aws.StringValue(input.StackName), aws.StringValue(input.ChangeSetName))
+	if err != nil {
+		return nil, err
+	}
+	f.record("ExecuteChangeSet", cs.stackName)
+
+	if cs.execStatus != "AVAILABLE" {
+		return nil, awserr.New("InvalidChangeSetStatus", fmt.Sprintf("ChangeSet [%s] cannot be executed in its current status of [%s]", cs.id, cs.status), nil)
+	}
+
+	s, err := f.lookup(cs.stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.execStatus = "EXECUTE_COMPLETE"
+	s.templateBody = aws.StringValue(cs.input.TemplateBody)
+	s.templateURL = aws.StringValue(cs.input.TemplateURL)
+	s.parameters = previousValues(s.parameters, cs.input.Parameters)
+	s.capabilities = cs.input.Capabilities
+	s.tags = cs.input.Tags
+
+	// executing a change set makes every other change set of the stack obsolete
+	for _, other := range f.changeSets {
+		if other.stackName == cs.stackName && other.id != cs.id && other.execStatus == "AVAILABLE" {
+			other.execStatus = "OBSOLETE"
+		}
+	}
+
+	if cs.changeSetType == "CREATE" {
+		if f.takeFailure(s, "CREATE_FAILED") {
+			f.transition(s, input.ClientRequestToken, "CREATE_IN_PROGRESS", "ROLLBACK_IN_PROGRESS", "ROLLBACK_COMPLETE")
+		} else {
+			f.transition(s, input.ClientRequestToken, "CREATE_IN_PROGRESS", "CREATE_COMPLETE")
+		}
+	} else {
+		s.updated = aws.Time(f.now())
+		if f.takeFailure(s, "UPDATE_FAILED") {
+			f.transition(s, input.ClientRequestToken, "UPDATE_IN_PROGRESS", "UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE")
+		} else {
+			f.transition(s, input.ClientRequestToken, "UPDATE_IN_PROGRESS", "UPDATE_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_COMPLETE")
+		}
+	}
+
+	return &cloudformation.ExecuteChangeSetOutput{}, nil
+}
+
+func (f *FakeCloudFormation) ContinueUpdateRollback(input *cloudformation.ContinueUpdateRollbackInput) (*cloudformation.ContinueUpdateRollbackOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("ContinueUpdateRollback"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.StackName)
+	f.record("ContinueUpdateRollback", name)
+
+	s, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.status != "UPDATE_ROLLBACK_FAILED" {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack:%s is in %s state and can not continue update rollback.", s.id, s.status), nil)
+	}
+
+	f.transition(s, input.ClientRequestToken, "UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE")
+	return &cloudformation.ContinueUpdateRollbackOutput{}, nil
+}
+
+func (f *FakeCloudFormation) CancelUpdateStack(input *cloudformation.CancelUpdateStackInput) (*cloudformation.CancelUpdateStackOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("CancelUpdateStack"); err != nil {
+		return nil, err
+	}
+
+	name := aws.StringValue(input.StackName)
+	f.record("CancelUpdateStack", name)
+
+	s, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.status != "UPDATE_IN_PROGRESS" {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("CancelUpdateStack cannot be called from current stack status [%s].", s.status), nil)
+	}
+
+	f.transition(s, input.ClientRequestToken, "UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE")
+	return &cloudformation.CancelUpdateStackOutput{}, nil
+}
+
+func (f *FakeCloudFormation) DeleteChangeSet(input *cloudformation.DeleteChangeSetInput) (*cloudformation.DeleteChangeSetOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("DeleteChangeSet"); err != nil {
+		return nil, err
+	}
+
+	cs, err := f.lookupChangeSet(aws.StringValue(input.StackName), aws.StringValue(input.ChangeSetName))
+	if err != nil {
+		return nil, err
+	}
+	f.record("DeleteChangeSet", cs.stackName)
+
+	if cs.execStatus == "EXECUTE_IN_PROGRESS" {
+		return nil, awserr.New("InvalidChangeSetStatus", fmt.Sprintf("ChangeSet [%s] cannot be deleted in its current status of [%s]", cs.id, cs.execStatus), nil)
+	}
+	delete(f.changeSets, cs.id)
+
+	return &cloudformation.DeleteChangeSetOutput{}, nil
+}
+
+//////// READ OPERATIONS ////////
+
+func (f *FakeCloudFormation) DescribeStacks(input *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("DescribeStacks"); err != nil {
+		return nil, err
+	}
+
+	if input.StackName == nil {
+		var stacks []*cloudformation.Stack
+		for _, s := range f.stacks {
+			stacks = append(stacks, f.describe(s))
+		}
+		return &cloudformation.DescribeStacksOutput{Stacks: stacks}, nil
+	}
+
+	s, err := f.lookup(aws.StringValue(input.StackName))
+	if err != nil {
+		return nil, err
+	}
+
+	out := &cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{f.describe(s)}}
+	if f.latency[s.name] == 0 {
+		f.advance(s)
+	}
+	return out, nil
+}
+
+func (f *FakeCloudFormation) ListStacks(input *cloudformation.ListStacksInput) (*cloudformation.ListStacksOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("ListStacks"); err != nil {
+		return nil, err
+	}
+
+	filter := make(map[string]bool)
+	for _, status := range input.StackStatusFilter {
+		filter[aws.StringValue(status)] = true
+	}
+
+	var summaries []*cloudformation.StackSummary
+	for _, s := range f.stacks {
+		if len(filter) > 0 && !filter[s.status] {
+			continue
+		}
+		summaries = append(summaries, &cloudformation.StackSummary{
+			StackId:      aws.String(s.id),
+			StackName:    aws.String(s.name),
+			StackStatus:  aws.String(s.status),
+			CreationTime: aws.Time(s.created),
+		})
+	}
+	return &cloudformation.ListStacksOutput{StackSummaries: summaries}, nil
+}
+
+func (f *FakeCloudFormation) DescribeChangeSet(input *cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("DescribeChangeSet"); err != nil {
+		return nil, err
+	}
+
+	cs, err := f.lookupChangeSet(aws.StringValue(input.StackName), aws.StringValue(input.ChangeSetName))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := f.lookup(cs.stackName)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &cloudformation.DescribeChangeSetOutput{
+		ChangeSetId:     aws.String(cs.id),
+		ChangeSetName:   aws.String(cs.name),
+		StackId:         aws.String(s.id),
+		StackName:       aws.String(s.name),
+		Status:          aws.String(cs.status),
+		ExecutionStatus: aws.String(cs.execStatus),
+		Changes:         cs.changes,
+		Parameters:      cs.input.Parameters,
+		Capabilities:    cs.input.Capabilities,
+		Tags:            cs.input.Tags,
+		CreationTime:    aws.Time(cs.created),
+	}
+	if cs.reason != "" {
+		out.StatusReason = aws.String(cs.reason)
+	}
+	return out, nil
+}
+
+func (f *FakeCloudFormation) DescribeStackEvents(input *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.throttled("DescribeStackEvents"); err != nil {
+		return nil, err
+	}
+
+	// the events of a deleted stack can only be read with its stack id
+	s, err := f.lookup(aws.StringValue(input.StackName))
+	if deleted, ok := f.deleted[aws.StringValue(input.StackName)]; ok {
+		s, err = deleted, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// CloudFormation returns the events in reverse chronological order
+	var events []*cloudformation.StackEvent
+	for i := len(s.events) - 1; i >= 0; i-- {
+		events = append(events, s.events[i])
+	}
+	return &cloudformation.DescribeStackEventsOutput{StackEvents: events}, nil
+}
+
+//////// WAIT OPERATIONS ////////
+
+// wait settles the stack and reports success when it ends up in one of the
+// expected statuses. A stack that disappeared is a success only when
+// deleted is true.
+func (f *FakeCloudFormation) wait(ctx aws.Context, name string, deleted bool, expected ...string) error {
+	f.mu.Lock()
+	latency := f.latency[name]
+	f.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return awserr.New(request.CanceledErrorCode, "waiter context canceled", ctx.Err())
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, err := f.lookup(name)
+	if err != nil {
+		if deleted {
+			return nil
+		}
+		return waiterError()
+	}
+
+	f.settle(s)
+	if _, err := f.lookup(name); err != nil && deleted {
+		return nil
+	}
+
+	for _, status := range expected {
+		if s.status == status {
+			return nil
+		}
+	}
+	return waiterError()
+}
+
+func (f *FakeCloudFormation) WaitUntilStackCreateComplete(input *cloudformation.DescribeStacksInput) error {
+	return f.WaitUntilStackCreateCompleteWithContext(aws.BackgroundContext(), input)
+}
+
+func (f *FakeCloudFormation) WaitUntilStackCreateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "waiter context canceled", err)
+	}
+	return f.wait(ctx, aws.StringValue(input.StackName), false, "CREATE_COMPLETE")
+}
+
+func (f *FakeCloudFormation) WaitUntilStackUpdateComplete(input *cloudformation.DescribeStacksInput) error {
+	return f.WaitUntilStackUpdateCompleteWithContext(aws.BackgroundContext(), input)
+}
+
+func (f *FakeCloudFormation) WaitUntilStackUpdateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "waiter context canceled", err)
+	}
+	return f.wait(ctx, aws.StringValue(input.StackName), false, "UPDATE_COMPLETE")
+}
+
+func (f *FakeCloudFormation) WaitUntilStackDeleteComplete(input *cloudformation.DescribeStacksInput) error {
+	return f.WaitUntilStackDeleteCompleteWithContext(aws.BackgroundContext(), input)
+}
+
+func (f *FakeCloudFormation) WaitUntilStackDeleteCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeStacksInput, opts ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "waiter context canceled", err)
+	}
+	return f.wait(ctx, aws.StringValue(input.StackName), true, "DELETE_COMPLETE")
+}
+
+func (f *FakeCloudFormation) WaitUntilChangeSetCreateComplete(input *cloudformation.DescribeChangeSetInput) error {
+	return f.WaitUntilChangeSetCreateCompleteWithContext(aws.BackgroundContext(), input)
+}
+
+func (f *FakeCloudFormation) WaitUntilChangeSetCreateCompleteWithContext(ctx aws.Context, input *cloudformation.DescribeChangeSetInput, opts ...request.WaiterOption) error {
+	if err := ctx.Err(); err != nil {
+		return awserr.New(request.CanceledErrorCode, "waiter context canceled", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cs, err := f.lookupChangeSet(aws.StringValue(input.StackName), aws.StringValue(input.ChangeSetName))
+	if err != nil {
+		return waiterError()
+	}
+	if cs.status != "CREATE_COMPLETE" {
+		return waiterError()
+	}
+	return nil
+}
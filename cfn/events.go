@@ -0,0 +1,165 @@
+package cfn
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+const defaultEventPollInterval = 5 * time.Second
+
+// maxEventPages limits the pages fetched by a single poll, a page holds up to 100 events
+const maxEventPages = 10
+
+func (cm CFNManager) DescribeStackEvents(ctx context.Context, stackName string, nextToken *string) (*cloudformation.DescribeStackEventsOutput, error) {
+	input := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+		NextToken: nextToken,
+	}
+
+	var res *cloudformation.DescribeStackEventsOutput
+	err := cm.retry(ctx, "DescribeStackEvents", func() (err error) {
+		res, err = cm.Client.DescribeStackEvents(input)
+		return err
+	})
+	return res, err
+}
+
+// LatestStackEvent returns the most recent event of the stack, nil when the stack doesn't exist
+func (cm CFNManager) LatestStackEvent(ctx context.Context, stackName string) *cloudformation.StackEvent {
+	res, err := cm.DescribeStackEvents(ctx, stackName, nil)
+	if err != nil || len(res.StackEvents) == 0 {
+		return nil
+	}
+	return res.StackEvents[0]
+}
+
+// newStackEvents returns the events that happened after the lastEventID one, in chronological order
+func (cm CFNManager) newStackEvents(ctx context.Context, stackID string, lastEventID string) ([]*cloudformation.StackEvent, error) {
+	var events []*cloudformation.StackEvent
+	var nextToken *string
+
+	for page := 0; page < maxEventPages; page++ {
+		res, err := cm.DescribeStackEvents(ctx, stackID, nextToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range res.StackEvents {
+			if aws.StringValue(e.EventId) == lastEventID {
+				return reverseEvents(events), nil
+			}
+			events = append(events, e)
+		}
+
+		if res.NextToken == nil {
+			break
+		}
+		nextToken = res.NextToken
+	}
+
+	return reverseEvents(events), nil
+}
+
+func reverseEvents(events []*cloudformation.StackEvent) []*cloudformation.StackEvent {
+	reversed := make([]*cloudformation.StackEvent, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		reversed = append(reversed, events[i])
+	}
+	return reversed
+}
+
+// StackEventType tells whether the event is about the stack itself, stack_status, or one of its resources, stack_resource
+func StackEventType(e *cloudformation.StackEvent) string {
+	if aws.StringValue(e.PhysicalResourceId) == aws.StringValue(e.StackId) {
+		return "stack_status"
+	}
+	return "stack_resource"
+}
+
+func logStackEvent(ctx context.Context, e *cloudformation.StackEvent) {
+	ctx = logger.WithEvent(ctx, StackEventType(e))
+	status := aws.StringValue(e.ResourceStatus)
+	line := aws.StringValue(e.LogicalResourceId) + " (" + aws.StringValue(e.ResourceType) + ") " + status
+	if reason := aws.StringValue(e.ResourceStatusReason); reason != "" {
+		line += ": " + reason
+	}
+
+	if strings.HasSuffix(status, "_FAILED") {
+		logger.Log.WarnCtxf(ctx, "%s\n", line)
+	} else {
+		logger.Log.InfoCtxf(ctx, "%s\n", line)
+	}
+}
+
+/*
+watchStackEvents logs the resource events of the stack as they happen, starting after the lastEventID event, and hands them to OnStackEvent.
+The returned function stops the polling once the remaining events are logged.
+stackID should be the stack id rather than its name so that the events of deleted stacks can still be read.
+*/
+func (cm CFNManager) watchStackEvents(ctx context.Context, stackID string, lastEventID string) (stop func()) {
+	interval := cm.EventPollInterval
+	if interval <= 0 {
+		interval = defaultEventPollInterval
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	poll := func() {
+		events, err := cm.newStackEvents(ctx, stackID, lastEventID)
+		if err != nil {
+			logger.Log.DebugCtxf(ctx, "Failed while fetching stack events: %s\n", err)
+			return
+		}
+
+		for _, e := range events {
+			logStackEvent(ctx, e)
+			if cm.OnStackEvent != nil {
+				cm.OnStackEvent(ctx, e)
+			}
+			lastEventID = aws.StringValue(e.EventId)
+		}
+	}
+
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				poll()
+				return
+			case <-ticker.C:
+				poll()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func eventID(e *cloudformation.StackEvent) string {
+	if e == nil {
+		return ""
+	}
+	return aws.StringValue(e.EventId)
+}
+
+func stackID(e *cloudformation.StackEvent) string {
+	if e == nil {
+		return ""
+	}
+	return aws.StringValue(e.StackId)
+}
@@ -0,0 +1,98 @@
+package cfn
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+// captureLogs redirects the info and warn logs into the returned buffer until the test finishes
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	info, warn := logger.Log.Info, logger.Log.Warn
+	logger.Log.Info = log.New(&buf, "[INFO] ", 0)
+	logger.Log.Warn = log.New(&buf, "[WARN] ", 0)
+	t.Cleanup(func() {
+		logger.Log.Info, logger.Log.Warn = info, warn
+	})
+	return &buf
+}
+
+func TestWatchStackEvents(t *testing.T) {
+	ctx := context.WithValue(context.Background(), logger.StackKey, "s1")
+
+	t.Log("When the stack create fails")
+	{
+		buf := captureLogs(t)
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Fail("s1", "Queue", "Resource limit exceeded")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.ApplyChanges(ctx, cm); err == nil {
+			t.Fatal("ApplyChanges should return error but found nil")
+		}
+
+		logs := buf.String()
+		expected := []string{
+			"[INFO] [STACK: s1] s1 (AWS::CloudFormation::Stack) CREATE_IN_PROGRESS: User Initiated",
+			"[WARN] [STACK: s1] Queue (AWS::CloudFormation::CustomResource) CREATE_FAILED: Resource limit exceeded",
+			"[INFO] [STACK: s1] s1 (AWS::CloudFormation::Stack) ROLLBACK_COMPLETE",
+		}
+		last := -1
+		for _, e := range expected {
+			i := strings.Index(logs, e)
+			if i <= last {
+				t.Fatalf("Expected %q to be logged in order but got logs:\n%s", e, logs)
+			}
+			last = i
+		}
+	}
+
+	t.Log("When the stack is updated only the new events are logged")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		buf := captureLogs(t)
+		s.Parameters = map[string]string{"Env": "prod"}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		logs := buf.String()
+		if strings.Contains(logs, "(AWS::CloudFormation::Stack) CREATE_") {
+			t.Fatalf("Expected the create events to be skipped but got logs:\n%s", logs)
+		}
+		if !strings.Contains(logs, "s1 (AWS::CloudFormation::Stack) UPDATE_COMPLETE") {
+			t.Fatalf("Expected the update events to be logged but got logs:\n%s", logs)
+		}
+	}
+
+	t.Log("When the stack is deleted")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "CREATE_COMPLETE")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1"}
+
+		buf := captureLogs(t)
+		if _, err := s.Destroy(ctx, cm); err != nil {
+			t.Fatalf("Destroy should return nil but found error: %s", err)
+		}
+
+		logs := buf.String()
+		if !strings.Contains(logs, "s1 (AWS::CloudFormation::Stack) DELETE_COMPLETE") {
+			t.Fatalf("Expected the delete events to be logged but got logs:\n%s", logs)
+		}
+	}
+}
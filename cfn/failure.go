@@ -0,0 +1,154 @@
+package cfn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const nestedStackType = "AWS::CloudFormation::Stack"
+
+// maxNestedDepth limits how deep the nested stacks are walked while looking for the root cause
+const maxNestedDepth = 5
+
+/*
+StackError is returned when a stack operation ends in a failed or rolled back status.
+It carries the first failed resource found in the stack events, walking down the
+nested stacks, so that the reason of the failure is reported instead of the waiter error.
+*/
+type StackError struct {
+	StackName string
+	// Operation is the waited operation, e.g. CreateStack
+	Operation string
+	// Status is the stack status once the operation stopped
+	Status            string
+	LogicalResourceID string
+	ResourceType      string
+	ResourceStatus    string
+	Reason            string
+	// NestedStacks is the path of nested stack logical ids holding the failed resource
+	NestedStacks []string
+	Err          error
+}
+
+func (e *StackError) Error() string {
+	msg := fmt.Sprintf("Wait %s failed, ERROR: %s", e.Operation, e.Err)
+	if rc := e.RootCause(); rc != "" {
+		msg += fmt.Sprintf(", ROOT CAUSE: %s", rc)
+	}
+	return msg
+}
+
+func (e *StackError) Unwrap() error {
+	return e.Err
+}
+
+// RootCause describes the failed resource, empty when it couldn't be found
+func (e *StackError) RootCause() string {
+	if e.LogicalResourceID == "" {
+		return ""
+	}
+
+	resource := strings.Join(append(append([]string{}, e.NestedStacks...), e.LogicalResourceID), "/")
+	rc := fmt.Sprintf("%s (%s) %s", resource, e.ResourceType, e.ResourceStatus)
+	if e.Reason != "" {
+		rc += ": " + e.Reason
+	}
+	return rc
+}
+
+/*
+stackError builds the StackError of a failed operation from the events that happened after the lastEventID one.
+The stack is looked up by its id so that the events of a deleted stack are still readable.
+*/
+func (cm CFNManager) stackError(ctx context.Context, operation, stackName, stackID, lastEventID string, err error) error {
+	serr := &StackError{StackName: stackName, Operation: operation, Err: err}
+	if stackID == "" {
+		return serr
+	}
+
+	if res, derr := cm.DescribeStacks(ctx, stackID); derr == nil && len(res.Stacks) > 0 {
+		serr.Status = aws.StringValue(res.Stacks[0].StackStatus)
+	}
+
+	events, eerr := cm.newStackEvents(ctx, stackID, lastEventID)
+	if eerr != nil || len(events) == 0 {
+		return serr
+	}
+
+	e, nested := cm.failedResource(ctx, stackID, events, 0)
+	if e == nil {
+		e = stackReason(stackID, events)
+	}
+	if e != nil {
+		serr.LogicalResourceID = aws.StringValue(e.LogicalResourceId)
+		serr.ResourceType = aws.StringValue(e.ResourceType)
+		serr.ResourceStatus = aws.StringValue(e.ResourceStatus)
+		serr.Reason = aws.StringValue(e.ResourceStatusReason)
+		serr.NestedStacks = nested
+	}
+	return serr
+}
+
+func isStackEvent(stackID string, e *cloudformation.StackEvent) bool {
+	return aws.StringValue(e.PhysicalResourceId) == stackID && aws.StringValue(e.ResourceType) == nestedStackType
+}
+
+/*
+failedResource returns the first failed resource event of the chronological events of the stack.
+A failed nested stack is replaced by the first failed resource of its own events, if any,
+along with the path of the nested stack logical ids.
+*/
+func (cm CFNManager) failedResource(ctx context.Context, stackID string, events []*cloudformation.StackEvent, depth int) (*cloudformation.StackEvent, []string) {
+	for _, e := range events {
+		if isStackEvent(stackID, e) || !strings.HasSuffix(aws.StringValue(e.ResourceStatus), "_FAILED") {
+			continue
+		}
+
+		nestedID := aws.StringValue(e.PhysicalResourceId)
+		if aws.StringValue(e.ResourceType) != nestedStackType || nestedID == "" || depth >= maxNestedDepth {
+			return e, nil
+		}
+
+		nestedEvents, err := cm.newStackEvents(ctx, nestedID, "")
+		if err != nil {
+			return e, nil
+		}
+		if nested, path := cm.failedResource(ctx, nestedID, eventsSince(nestedEvents, events[0].Timestamp), depth+1); nested != nil {
+			return nested, append([]string{aws.StringValue(e.LogicalResourceId)}, path...)
+		}
+		return e, nil
+	}
+
+	return nil, nil
+}
+
+// stackReason returns the first stack level event explaining the failure, e.g. the one starting the rollback
+func stackReason(stackID string, events []*cloudformation.StackEvent) *cloudformation.StackEvent {
+	for _, e := range events {
+		reason := aws.StringValue(e.ResourceStatusReason)
+		if isStackEvent(stackID, e) && reason != "" && reason != "User Initiated" {
+			return e
+		}
+	}
+	return nil
+}
+
+// eventsSince drops the events older than the given time, e.g. the ones of a previous operation
+func eventsSince(events []*cloudformation.StackEvent, since *time.Time) []*cloudformation.StackEvent {
+	if since == nil {
+		return events
+	}
+
+	var recent []*cloudformation.StackEvent
+	for _, e := range events {
+		if e.Timestamp == nil || !e.Timestamp.Before(*since) {
+			recent = append(recent, e)
+		}
+	}
+	return recent
+}
@@ -0,0 +1,93 @@
+package cfn
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+)
+
+func TestStackError(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the stack create fails")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Fail("s1", "Queue", "Resource limit exceeded")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		_, err := s.ApplyChanges(ctx, cm)
+		var serr *StackError
+		if !errors.As(err, &serr) {
+			t.Fatalf("Expected StackError but got %v", err)
+		}
+		if serr.LogicalResourceID != "Queue" || serr.ResourceStatus != "CREATE_FAILED" || serr.Reason != "Resource limit exceeded" {
+			t.Fatalf("Expected Queue CREATE_FAILED root cause but got %+v", serr)
+		}
+		if serr.Status != "ROLLBACK_COMPLETE" {
+			t.Fatalf("Expected stack status to be ROLLBACK_COMPLETE but got %s", serr.Status)
+		}
+		if !strings.Contains(err.Error(), "Wait CreateStack failed") || !strings.Contains(err.Error(), "Resource limit exceeded") {
+			t.Fatalf("Expected the error to include the waiter error and the reason but got %s", err)
+		}
+	}
+
+	t.Log("When the stack update fails after a previous failure")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		fake.Fail("s1", "Topic", "Topic already exists")
+		s.Parameters = map[string]string{"Env": "prod"}
+		if _, err := s.ApplyChanges(ctx, cm); err == nil {
+			t.Fatal("ApplyChanges should return error but found nil")
+		}
+
+		fake.Fail("s1", "Queue", "Access denied")
+		s.Parameters = map[string]string{"Env": "dev"}
+		_, err := s.ApplyChanges(ctx, cm)
+		var serr *StackError
+		if !errors.As(err, &serr) || serr.LogicalResourceID != "Queue" || serr.ResourceStatus != "UPDATE_FAILED" {
+			t.Fatalf("Expected Queue UPDATE_FAILED root cause of the last update but got %v", err)
+		}
+	}
+
+	t.Log("When a resource of a nested stack fails")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.FailNested("s1", "Network", "Vpc", "The maximum number of VPCs has been reached")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		_, err := s.ApplyChanges(ctx, cm)
+		var serr *StackError
+		if !errors.As(err, &serr) {
+			t.Fatalf("Expected StackError but got %v", err)
+		}
+		if rc := serr.RootCause(); rc != "Network/Vpc (AWS::CloudFormation::CustomResource) CREATE_FAILED: The maximum number of VPCs has been reached" {
+			t.Fatalf("Expected the nested Vpc root cause but got %q", rc)
+		}
+	}
+
+	t.Log("When the stack delete fails")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "CREATE_COMPLETE")
+		fake.Fail("s1", "Bucket", "The bucket you tried to delete is not empty")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1"}
+
+		_, err := s.Destroy(ctx, cm)
+		var serr *StackError
+		if !errors.As(err, &serr) || serr.LogicalResourceID != "Bucket" || serr.Status != "DELETE_FAILED" {
+			t.Fatalf("Expected Bucket root cause with DELETE_FAILED status but got %v", err)
+		}
+	}
+}
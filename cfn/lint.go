@@ -0,0 +1,237 @@
+package cfn
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxTemplateBodySize is the largest template CloudFormation accepts as a body, the larger ones need a template_url
+const MaxTemplateBodySize = 51200
+
+// templateSections are the sections of a template whose entries are named by a logical ID
+var templateSections = []string{"Parameters", "Mappings", "Conditions", "Resources", "Outputs"}
+
+// subVarPattern matches the variables of Fn::Sub, ${!Literal} being written as is
+var subVarPattern = regexp.MustCompile(`\$\{([^!}][^}]*)\}`)
+
+// linter collects the problems of a template while its elements are walked through
+type linter struct {
+	t         *Template
+	resources map[string]bool
+	used      map[string]bool
+	problems  []TemplateProblem
+}
+
+/*
+Lint runs offline checks on the template: duplicate sections and logical IDs, a missing Resources section,
+Ref, GetAtt and Sub targets that aren't declared, DependsOn on unknown resources, parameters that are never
+referenced and a body larger than MaxTemplateBodySize. The references aren't checked in the templates using
+a Transform, e.g. SAM, as the transform declares resources of its own. Unused parameters are only warnings.
+*/
+func (t *Template) Lint() []TemplateProblem {
+	l := linter{t: t, resources: make(map[string]bool), used: make(map[string]bool)}
+
+	if t.Size > MaxTemplateBodySize {
+		l.problem(0, false, "the template is %d bytes, more than the %d bytes of a template body, upload it to S3 and use template_url", t.Size, MaxTemplateBodySize)
+	}
+
+	sections := make(map[string]int)
+	for i := 0; i+1 < len(t.root.Content); i += 2 {
+		key := t.root.Content[i]
+		if line, ok := sections[key.Value]; ok {
+			l.problem(key.Line, false, "duplicate %s section, first declared on line %d", key.Value, line)
+			continue
+		}
+		sections[key.Value] = key.Line
+	}
+	for _, section := range templateSections {
+		l.duplicateIDs(section)
+	}
+
+	_, resources := mappingEntry(t.root, "Resources")
+	if resources == nil {
+		l.problem(1, false, "the template has no Resources section")
+		return l.problems
+	}
+	if resources.Kind != yaml.MappingNode {
+		l.problem(resources.Line, false, "the Resources section should be a map of the resources")
+		return l.problems
+	}
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		name := resources.Content[i]
+		l.resources[name.Value] = true
+		if p, ok := t.Parameter(name.Value); ok {
+			l.problem(name.Line, false, "logical ID %s is already used by the parameter on line %d", name.Value, p.Line)
+		}
+	}
+
+	if _, transform := mappingEntry(t.root, "Transform"); transform != nil {
+		return l.problems
+	}
+
+	for i := 0; i+1 < len(resources.Content); i += 2 {
+		l.dependsOn(resources.Content[i].Value, resources.Content[i+1])
+	}
+	for i := 0; i+1 < len(t.root.Content); i += 2 {
+		if t.root.Content[i].Value != "Parameters" {
+			l.walk(t.root.Content[i+1])
+		}
+	}
+	for _, p := range t.Parameters {
+		if !l.used[p.Name] {
+			l.problem(p.Line, true, "parameter %s is never referenced", p.Name)
+		}
+	}
+	return l.problems
+}
+
+func (l *linter) problem(line int, warning bool, format string, a ...interface{}) {
+	l.problems = append(l.problems, TemplateProblem{File: l.t.File, Line: line, Warning: warning, Message: fmt.Sprintf(format, a...)})
+}
+
+// duplicateIDs reports the logical IDs declared twice in a section, YAML and JSON parsers keeping only the last one
+func (l *linter) duplicateIDs(section string) {
+	_, m := mappingEntry(l.t.root, section)
+	if m == nil || m.Kind != yaml.MappingNode {
+		return
+	}
+
+	lines := make(map[string]int)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		key := m.Content[i]
+		if line, ok := lines[key.Value]; ok {
+			l.problem(key.Line, false, "duplicate logical ID %s in %s, first declared on line %d", key.Value, section, line)
+			continue
+		}
+		lines[key.Value] = key.Line
+	}
+}
+
+// dependsOn reports the DependsOn of the resource naming an unknown resource or the resource itself
+func (l *linter) dependsOn(resource string, r *yaml.Node) {
+	if r.Kind != yaml.MappingNode {
+		return
+	}
+	_, deps := mappingEntry(r, "DependsOn")
+	if deps == nil {
+		return
+	}
+
+	targets := []*yaml.Node{deps}
+	if deps.Kind == yaml.SequenceNode {
+		targets = deps.Content
+	}
+	for _, dep := range targets {
+		switch {
+		case dep.Kind != yaml.ScalarNode:
+			l.problem(dep.Line, false, "DependsOn of resource %s should be a resource name or a list of them", resource)
+		case dep.Value == resource:
+			l.problem(dep.Line, false, "resource %s depends on itself", resource)
+		case !l.resources[dep.Value]:
+			l.problem(dep.Line, false, "resource %s depends on %s which is not a resource of the template", resource, dep.Value)
+		}
+	}
+}
+
+// walk checks the references of the node and of its children, in their long and short forms
+func (l *linter) walk(n *yaml.Node) {
+	switch n.Tag {
+	case "!Ref":
+		l.ref(n.Line, n.Value, "Ref "+n.Value)
+		return
+	case "!GetAtt":
+		l.getAtt(n)
+		return
+	case "!Sub":
+		l.sub(n)
+		return
+	}
+
+	if n.Kind == yaml.MappingNode && len(n.Content) == 2 {
+		key, value := n.Content[0], n.Content[1]
+		switch key.Value {
+		case "Ref":
+			if value.Kind == yaml.ScalarNode {
+				l.ref(value.Line, value.Value, "Ref "+value.Value)
+				return
+			}
+		case "Fn::GetAtt":
+			l.getAtt(value)
+			return
+		case "Fn::Sub":
+			l.sub(value)
+			return
+		}
+	}
+
+	for _, c := range n.Content {
+		l.walk(c)
+	}
+}
+
+// ref checks that the target of a Ref, or of a Sub variable, is a parameter, a resource or a pseudo parameter
+func (l *linter) ref(line int, target, what string) {
+	if _, ok := l.t.Parameter(target); ok {
+		l.used[target] = true
+		return
+	}
+	if !strings.HasPrefix(target, "AWS::") && !l.resources[target] {
+		l.problem(line, false, "%s is neither a parameter nor a resource of the template", what)
+	}
+}
+
+// getAtt checks that the target of a GetAtt, written Resource.Attribute or [Resource, Attribute], is a resource
+func (l *linter) getAtt(n *yaml.Node) {
+	var target string
+	switch {
+	case n.Kind == yaml.ScalarNode:
+		target = strings.SplitN(n.Value, ".", 2)[0]
+	case n.Kind == yaml.SequenceNode && len(n.Content) == 2 && n.Content[0].Kind == yaml.ScalarNode:
+		target = n.Content[0].Value
+		// the attribute name may come from a Ref
+		l.walk(n.Content[1])
+	default:
+		l.problem(n.Line, false, "GetAtt should be written Resource.Attribute or [Resource, Attribute]")
+		return
+	}
+
+	if !l.resources[target] {
+		l.problem(n.Line, false, "GetAtt %s is not a resource of the template", target)
+	}
+}
+
+// sub checks the variables of a Sub, written as a string or as [String, {Var: Value}] declaring its own variables
+func (l *linter) sub(n *yaml.Node) {
+	str := n
+	locals := make(map[string]bool)
+	if n.Kind == yaml.SequenceNode && len(n.Content) == 2 {
+		str = n.Content[0]
+		if vars := n.Content[1]; vars.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(vars.Content); i += 2 {
+				locals[vars.Content[i].Value] = true
+				l.walk(vars.Content[i+1])
+			}
+		}
+	}
+	if str.Kind != yaml.ScalarNode {
+		l.problem(n.Line, false, "Sub should be written as a string or [String, {Var: Value}]")
+		return
+	}
+
+	for _, m := range subVarPattern.FindAllStringSubmatch(str.Value, -1) {
+		name := strings.TrimSpace(m[1])
+		if locals[name] {
+			continue
+		}
+		if i := strings.Index(name, "."); i > 0 && !strings.HasPrefix(name, "AWS::") {
+			if !l.resources[name[:i]] {
+				l.problem(str.Line, false, "Sub variable ${%s} is not an attribute of a resource of the template", name)
+			}
+			continue
+		}
+		l.ref(str.Line, name, "Sub variable ${"+name+"}")
+	}
+}
@@ -0,0 +1,126 @@
+package cfn
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	t.Log("When the template is valid")
+	{
+		tpl, err := ParseTemplate(writeTemplate(t, `Parameters:
+  Env:
+    Type: String
+  Size:
+    Type: Number
+Conditions:
+  IsProd: !Equals [!Ref Env, prod]
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+  Bucket:
+    Type: AWS::S3::Bucket
+    DependsOn: [Queue]
+    Properties:
+      BucketName: !Sub '${AWS::StackName}-${Env}-${!Literal}'
+      Tags:
+        - Key: Queue
+          Value: !Sub
+            - '${Arn}-${Count}'
+            - Arn: !GetAtt Queue.Arn
+              Count: {"Ref": "Size"}
+Outputs:
+  QueueArn:
+    Value: {"Fn::GetAtt": ["Queue", "Arn"]}
+`))
+		if err != nil {
+			t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+		}
+		if problems := tpl.Lint(); len(problems) != 0 {
+			t.Fatalf("Expected no problem but got %v", problems)
+		}
+	}
+
+	t.Log("When the template has problems")
+	{
+		tpl, err := ParseTemplate(writeTemplate(t, `Parameters:
+  Env:
+    Type: String
+  Unused:
+    Type: String
+Resources:
+  Queue:
+    Type: AWS::SQS::Queue
+    DependsOn: Queue
+  Bucket:
+    Type: AWS::S3::Bucket
+    DependsOn: [Topic]
+    Properties:
+      BucketName: !Sub '${Envv}-${Topic.Name}'
+      Tags:
+        - Key: Env
+          Value: !Ref Env
+        - Key: Queue
+          Value: !GetAtt Queuee.Arn
+  Queue:
+    Type: AWS::SQS::Queue
+  Env:
+    Type: AWS::SNS::Topic
+Outputs:
+  Missing:
+    Value: {"Ref": "Nothing"}
+`))
+		if err != nil {
+			t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+		}
+
+		var messages []string
+		for _, p := range tpl.Lint() {
+			messages = append(messages, strings.TrimPrefix(p.String(), tpl.File+":"))
+		}
+		expected := []string{
+			"20: duplicate logical ID Queue in Resources, first declared on line 7",
+			"22: logical ID Env is already used by the parameter on line 2",
+			"9: resource Queue depends on itself",
+			"12: resource Bucket depends on Topic which is not a resource of the template",
+			"14: Sub variable ${Envv} is neither a parameter nor a resource of the template",
+			"14: Sub variable ${Topic.Name} is not an attribute of a resource of the template",
+			"19: GetAtt Queuee is not a resource of the template",
+			"26: Ref Nothing is neither a parameter nor a resource of the template",
+			"4: warning: parameter Unused is never referenced",
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Fatalf("Expected the problems\n%s\nbut got\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+		}
+	}
+
+	t.Log("When the template is too large for a body")
+	{
+		path := filepath.Join(t.TempDir(), "template.yml")
+		body := "Resources:\n  Queue:\n    Type: AWS::SQS::Queue\n" + strings.Repeat("# padding\n", MaxTemplateBodySize/10)
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tpl, err := ParseTemplate(path)
+		if err != nil {
+			t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+		}
+		if problems := tpl.Lint(); len(problems) != 1 || problems[0].Line != 0 || !strings.Contains(problems[0].Message, "template_url") {
+			t.Fatalf("Expected the size of the template to be reported but got %v", problems)
+		}
+	}
+
+	t.Log("When the template uses a transform")
+	{
+		tpl, err := ParseTemplate(writeTemplate(t, "Transform: AWS::Serverless-2016-10-31\nResources:\n  Function:\n    Type: AWS::Serverless::Function\n    Properties:\n      Role: !GetAtt FunctionRole.Arn\n"))
+		if err != nil {
+			t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+		}
+		if problems := tpl.Lint(); len(problems) != 0 {
+			t.Fatalf("Expected the references of the transform not to be checked but got %v", problems)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cfn
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+var outputRefPattern = regexp.MustCompile(`\{\{\s*output\s+"([^"]+)"\s+"([^"]+)"\s*\}\}`)
+
+// OutputRef references the output of another stack, written as {{ output "stack-name" "OutputKey" }} in parameter values
+type OutputRef struct {
+	StackName string
+	OutputKey string
+}
+
+func (o OutputRef) String() string {
+	return fmt.Sprintf("{{ output %q %q }}", o.StackName, o.OutputKey)
+}
+
+// OutputRefs returns the stack outputs referenced by the stack parameters
+func (s *Stack) OutputRefs() []OutputRef {
+	var refs []OutputRef
+	seen := make(map[OutputRef]bool)
+
+	keys := make([]string, 0, len(s.Parameters))
+	for k := range s.Parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, m := range outputRefPattern.FindAllStringSubmatch(s.Parameters[k], -1) {
+			ref := OutputRef{StackName: m[1], OutputKey: m[2]}
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+	}
+	return refs
+}
+
+/*
+ResolveOutputs returns a copy of the stack where the output references in the
+parameters are replaced by the current output values of the referenced stacks.
+It fails when a referenced stack doesn't exist or doesn't expose the output.
+*/
+func (s Stack) ResolveOutputs(ctx context.Context, cm CFNManager) (Stack, error) {
+	refs := s.OutputRefs()
+	if len(refs) == 0 {
+		return s, nil
+	}
+
+	outputs := make(map[string]map[string]string)
+	for _, ref := range refs {
+		if _, ok := outputs[ref.StackName]; ok {
+			continue
+		}
+
+		res, err := cm.DescribeStacks(ctx, ref.StackName)
+		if err != nil {
+			return s, fmt.Errorf("failed while fetching outputs of stack %s: %s", ref.StackName, err)
+		}
+
+		values := make(map[string]string)
+		for _, o := range res.Stacks[0].Outputs {
+			values[aws.StringValue(o.OutputKey)] = aws.StringValue(o.OutputValue)
+		}
+		outputs[ref.StackName] = values
+	}
+
+	params := make(map[string]string, len(s.Parameters))
+	for k, v := range s.Parameters {
+		var missing error
+		params[k] = outputRefPattern.ReplaceAllStringFunc(v, func(m string) string {
+			sm := outputRefPattern.FindStringSubmatch(m)
+			value, ok := outputs[sm[1]][sm[2]]
+			if !ok {
+				missing = fmt.Errorf("output %s not found in stack %s, required by parameter %s", sm[2], sm[1], k)
+				return m
+			}
+			logger.Log.DebugCtxf(ctx, "Resolved parameter %s from output %s of stack %s\n", k, sm[2], sm[1])
+			return value
+		})
+
+		if missing != nil {
+			return s, missing
+		}
+	}
+
+	s.Parameters = params
+	return s, nil
+}
@@ -0,0 +1,228 @@
+package cfn
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"gopkg.in/yaml.v2"
+)
+
+// cliParameter is a parameter of the CloudFormation CLI parameters file, e.g. [{"ParameterKey": "Env", "ParameterValue": "dev"}]
+type cliParameter struct {
+	ParameterKey     string `yaml:"ParameterKey"`
+	ParameterValue   string `yaml:"ParameterValue"`
+	UsePreviousValue bool   `yaml:"UsePreviousValue"`
+}
+
+/*
+ReadParametersFile reads the parameters of a parameters file, written either in the CloudFormation CLI format,
+a JSON list of ParameterKey and ParameterValue, or as a YAML or JSON map of the parameter values.
+It also returns the keys of the CLI format parameters set with UsePreviousValue.
+*/
+func ReadParametersFile(path string) (map[string]string, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var list []cliParameter
+	if err := yaml.Unmarshal(data, &list); err == nil {
+		params := make(map[string]string, len(list))
+		var previous []string
+		for i, p := range list {
+			if p.ParameterKey == "" {
+				return nil, nil, fmt.Errorf("ParameterKey of the %d index parameter is empty in %s", i, path)
+			}
+			if p.UsePreviousValue {
+				previous = append(previous, p.ParameterKey)
+				continue
+			}
+			params[p.ParameterKey] = p.ParameterValue
+		}
+		return params, previous, nil
+	}
+
+	var params map[string]string
+	if err := yaml.Unmarshal(data, &params); err != nil {
+		return nil, nil, fmt.Errorf("%s should be a list of ParameterKey and ParameterValue or a map of the parameter values: %s", path, err)
+	}
+	return params, nil, nil
+}
+
+/*
+LoadParametersFile returns a copy of the stack whose parameters are merged with the ones of its parameters_file.
+The inline parameters take precedence over the file, the UsePreviousValue parameters of the file are added to use_previous_value.
+*/
+func (s Stack) LoadParametersFile() (Stack, error) {
+	if s.ParametersFile == "" {
+		return s, nil
+	}
+
+	fileParams, previous, err := ReadParametersFile(s.ParametersFile)
+	if err != nil {
+		return s, fmt.Errorf("failed while reading the parameters_file of stack %s: %w", s.StackName, err)
+	}
+
+	params := make(map[string]string, len(fileParams)+len(s.Parameters))
+	for k, v := range fileParams {
+		params[k] = v
+	}
+	for k, v := range s.Parameters {
+		params[k] = v
+	}
+	s.Parameters = params
+
+	usePrevious := append([]string{}, s.UsePreviousValue...)
+	for _, k := range previous {
+		if !s.usesPreviousValue(k) {
+			usePrevious = append(usePrevious, k)
+		}
+	}
+	s.UsePreviousValue = usePrevious
+	return s, nil
+}
+
+func (s *Stack) usesPreviousValue(key string) bool {
+	for _, k := range s.UsePreviousValue {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+cfnParameters returns the parameters of the stack operations, sorted by key. Once the stack exists the use_previous_value
+parameters keep their current value, whatever their value in the compose file. A new stack gets their value if any,
+CloudFormation refusing the previous values for a stack that doesn't exist yet.
+*/
+func (s *Stack) cfnParameters(exists bool) []*cloudformation.Parameter {
+	keys := make([]string, 0, len(s.Parameters)+len(s.UsePreviousValue))
+	for k := range s.Parameters {
+		if !exists || !s.usesPreviousValue(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var parameters []*cloudformation.Parameter
+	for _, k := range keys {
+		parameters = append(parameters, &cloudformation.Parameter{ParameterKey: aws.String(k), ParameterValue: aws.String(s.Parameters[k])})
+	}
+	if exists {
+		for _, k := range s.UsePreviousValue {
+			parameters = append(parameters, &cloudformation.Parameter{ParameterKey: aws.String(k), UsePreviousValue: aws.Bool(true)})
+		}
+	}
+	return parameters
+}
+
+/*
+ValidateParameters compares the parameters of the stack with the ones declared by its template: the parameters
+without default should be given, the given ones should be declared and their values should meet the constraints
+of the template. The values referencing stack outputs are only known at deploy time so their constraints aren't checked.
+*/
+func (s *Stack) ValidateParameters(t *Template) []TemplateProblem {
+	var problems []TemplateProblem
+	problem := func(line int, format string, a ...interface{}) {
+		problems = append(problems, TemplateProblem{File: t.File, Line: line, StackName: s.StackName, Message: fmt.Sprintf(format, a...)})
+	}
+
+	keys := make([]string, 0, len(s.Parameters))
+	for k := range s.Parameters {
+		keys = append(keys, k)
+	}
+	keys = append(keys, s.UsePreviousValue...)
+	sort.Strings(keys)
+	for i, k := range keys {
+		if i > 0 && keys[i-1] == k {
+			continue
+		}
+		if _, ok := t.Parameter(k); !ok {
+			problem(t.ParametersLine, "parameter %s is not declared in the template", k)
+		}
+	}
+
+	for _, p := range t.Parameters {
+		value, ok := s.Parameters[p.Name]
+		switch {
+		case !ok && p.Default == nil && !s.usesPreviousValue(p.Name):
+			problem(p.Line, "parameter %s is required as it has no default value", p.Name)
+		case ok && !outputRefPattern.MatchString(value):
+			for _, err := range p.check(value) {
+				problem(p.Line, "parameter %s: %s", p.Name, err)
+			}
+		}
+	}
+	return problems
+}
+
+// check returns the constraints of the parameter that the value doesn't meet
+func (p TemplateParameter) check(value string) []string {
+	var violations []string
+
+	// the values of the list parameters are checked one by one
+	values := []string{value}
+	if p.Type == "CommaDelimitedList" || strings.HasPrefix(p.Type, "List<") {
+		values = strings.Split(value, ",")
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+	}
+
+	for _, v := range values {
+		if len(p.AllowedValues) > 0 && !contains(p.AllowedValues, v) {
+			violations = append(violations, fmt.Sprintf("value %q is not one of the AllowedValues: %s", v, strings.Join(p.AllowedValues, ", ")))
+		}
+		// CloudFormation matches the pattern against the whole value, Java regular expressions that Go can't compile are skipped
+		if pattern, err := regexp.Compile("^(?:" + p.AllowedPattern + ")$"); p.AllowedPattern != "" && err == nil && !pattern.MatchString(v) {
+			violations = append(violations, fmt.Sprintf("value %q doesn't match the AllowedPattern: %s", v, p.AllowedPattern))
+		}
+		if min, ok := parseConstraint(p.MinLength); ok && float64(utf8.RuneCountInString(v)) < min {
+			violations = append(violations, fmt.Sprintf("value %q is shorter than the MinLength: %s", v, *p.MinLength))
+		}
+		if max, ok := parseConstraint(p.MaxLength); ok && float64(utf8.RuneCountInString(v)) > max {
+			violations = append(violations, fmt.Sprintf("value %q is longer than the MaxLength: %s", v, *p.MaxLength))
+		}
+
+		if p.Type != "Number" && p.Type != "List<Number>" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			violations = append(violations, fmt.Sprintf("value %q is not a number", v))
+			continue
+		}
+		if min, ok := parseConstraint(p.MinValue); ok && n < min {
+			violations = append(violations, fmt.Sprintf("value %s is lower than the MinValue: %s", v, *p.MinValue))
+		}
+		if max, ok := parseConstraint(p.MaxValue); ok && n > max {
+			violations = append(violations, fmt.Sprintf("value %s is greater than the MaxValue: %s", v, *p.MaxValue))
+		}
+	}
+	return violations
+}
+
+func parseConstraint(c *string) (float64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(*c, 64)
+	return n, err == nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
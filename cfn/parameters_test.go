@@ -0,0 +1,177 @@
+package cfn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+)
+
+func writeParametersFile(t *testing.T, name, body string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("Failed to write parameters file: %s", err)
+	}
+	return path
+}
+
+func TestParametersFile(t *testing.T) {
+	t.Log("When the parameters file has the CloudFormation CLI format")
+	{
+		path := writeParametersFile(t, "params.json", `[
+  {"ParameterKey": "Env", "ParameterValue": "dev"},
+  {"ParameterKey": "DbPassword", "UsePreviousValue": true}
+]`)
+		params, previous, err := ReadParametersFile(path)
+		if err != nil {
+			t.Fatalf("ReadParametersFile should return nil but found error: %s", err)
+		}
+		if !reflect.DeepEqual(params, map[string]string{"Env": "dev"}) || !reflect.DeepEqual(previous, []string{"DbPassword"}) {
+			t.Fatalf("Expected Env and the previous value of DbPassword but got %v and %v", params, previous)
+		}
+	}
+
+	t.Log("When the parameters file is a YAML or JSON map")
+	{
+		for name, body := range map[string]string{"params.yml": "Env: dev\nSize: 2\n", "params.json": `{"Env": "dev", "Size": "2"}`} {
+			params, _, err := ReadParametersFile(writeParametersFile(t, name, body))
+			if err != nil {
+				t.Fatalf("ReadParametersFile should return nil for %s but found error: %s", name, err)
+			}
+			if !reflect.DeepEqual(params, map[string]string{"Env": "dev", "Size": "2"}) {
+				t.Fatalf("Expected the parameters of %s but got %v", name, params)
+			}
+		}
+	}
+
+	t.Log("When the parameters file is invalid")
+	{
+		for _, body := range []string{`[{"ParameterValue": "dev"}]`, "- dev\n- prod\n", "Env: [dev]\n"} {
+			if _, _, err := ReadParametersFile(writeParametersFile(t, "params.yml", body)); err == nil {
+				t.Fatalf("ReadParametersFile should return an error for %q", body)
+			}
+		}
+	}
+
+	t.Log("When the parameters file is merged with the inline parameters")
+	{
+		path := writeParametersFile(t, "params.json", `[{"ParameterKey": "Env", "ParameterValue": "dev"}, {"ParameterKey": "Size", "ParameterValue": "1"}, {"ParameterKey": "Token", "UsePreviousValue": true}]`)
+		s := Stack{StackName: "s1", ParametersFile: path, Parameters: map[string]string{"Env": "prod"}, UsePreviousValue: []string{"DbPassword"}}
+
+		loaded, err := s.LoadParametersFile()
+		if err != nil {
+			t.Fatalf("LoadParametersFile should return nil but found error: %s", err)
+		}
+		if !reflect.DeepEqual(loaded.Parameters, map[string]string{"Env": "prod", "Size": "1"}) {
+			t.Fatalf("Expected the inline parameters to take precedence but got %v", loaded.Parameters)
+		}
+		if !reflect.DeepEqual(loaded.UsePreviousValue, []string{"DbPassword", "Token"}) {
+			t.Fatalf("Expected the previous values of the stack and of the file but got %v", loaded.UsePreviousValue)
+		}
+		if len(s.Parameters) != 1 || len(s.UsePreviousValue) != 1 {
+			t.Fatalf("Expected the stack to be left untouched but got %v and %v", s.Parameters, s.UsePreviousValue)
+		}
+	}
+
+	t.Log("When a parameter keeps its previous value")
+	{
+		ctx := context.Background()
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		template := writeTemplate(t, "Resources: {}")
+		s := Stack{StackName: "s1", TemplateFile: template, Parameters: map[string]string{"Env": "dev", "DbPassword": "secret"}, UsePreviousValue: []string{"DbPassword"}}
+		if action, err := s.ApplyChanges(ctx, cm); err != nil || action != PlanCreate {
+			t.Fatalf("Expected the stack to be created with the given value but got %s, error: %v", action, err)
+		}
+
+		s = Stack{StackName: "s1", TemplateFile: template, Parameters: map[string]string{"Env": "prod"}, UsePreviousValue: []string{"DbPassword"}}
+		if action, err := s.ApplyChanges(ctx, cm); err != nil || action != PlanUpdate {
+			t.Fatalf("Expected the stack to be updated but got %s, error: %v", action, err)
+		}
+		if _, params := fake.StackInput("s1"); params["Env"] != "prod" || params["DbPassword"] != "secret" {
+			t.Fatalf("Expected DbPassword to keep its value but got %v", params)
+		}
+
+		i, err := s.createChangeSetInput(ctx, "UPDATE")
+		if err != nil {
+			t.Fatalf("createChangeSetInput should return nil but found error: %s", err)
+		}
+		last := i.Parameters[len(i.Parameters)-1]
+		if aws.StringValue(last.ParameterKey) != "DbPassword" || !aws.BoolValue(last.UsePreviousValue) || last.ParameterValue != nil {
+			t.Fatalf("Expected the change set to keep the previous value of DbPassword but got %v", i.Parameters)
+		}
+	}
+}
+
+func TestValidateParameters(t *testing.T) {
+	tpl, err := ParseTemplate(writeTemplate(t, `Parameters:
+  Env:
+    Type: String
+    AllowedValues: [dev, prod]
+  Name:
+    Type: String
+    AllowedPattern: '[a-z]+'
+    MaxLength: 5
+  Size:
+    Type: Number
+    Default: 2
+    MinValue: 1
+    MaxValue: 10
+  Zones:
+    Type: CommaDelimitedList
+    Default: a
+    AllowedValues: [a, b]
+  DbPassword:
+    Type: String
+    NoEcho: true
+Resources: {}
+`))
+	if err != nil {
+		t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+	}
+
+	t.Log("When the parameters meet the template")
+	{
+		s := Stack{StackName: "s1", Parameters: map[string]string{"Env": "dev", "Name": "app", "Size": "10", "Zones": "a, b", "DbPassword": "secret"}}
+		if problems := s.ValidateParameters(tpl); len(problems) != 0 {
+			t.Fatalf("Expected no problem but got %v", problems)
+		}
+	}
+
+	t.Log("When the parameters don't meet the template")
+	{
+		s := Stack{StackName: "s1", Parameters: map[string]string{"Env": "qa", "Name": "App-123", "Size": "11", "Zones": "a,c", "Envv": "dev"}}
+		problems := s.ValidateParameters(tpl)
+		expected := []string{
+			"parameter Envv is not declared in the template",
+			`parameter Env: value "qa" is not one of the AllowedValues: dev, prod`,
+			`parameter Name: value "App-123" doesn't match the AllowedPattern: [a-z]+`,
+			`parameter Name: value "App-123" is longer than the MaxLength: 5`,
+			"parameter Size: value 11 is greater than the MaxValue: 10",
+			`parameter Zones: value "c" is not one of the AllowedValues: a, b`,
+			"parameter DbPassword is required as it has no default value",
+		}
+		var messages []string
+		for _, p := range problems {
+			messages = append(messages, p.Message)
+		}
+		if !reflect.DeepEqual(messages, expected) {
+			t.Fatalf("Expected all the problems %q but got %q", expected, messages)
+		}
+		if problems[1].Line != 2 || problems[1].File != tpl.File || problems[1].StackName != "s1" {
+			t.Fatalf("Expected the problem to be located on the Env parameter but got %+v", problems[1])
+		}
+	}
+
+	t.Log("When a parameter keeps its previous value or references an output")
+	{
+		s := Stack{StackName: "s1", Parameters: map[string]string{"Env": `{{ output "vpc" "Env" }}`, "Name": "app"}, UsePreviousValue: []string{"DbPassword"}}
+		if problems := s.ValidateParameters(tpl); len(problems) != 0 {
+			t.Fatalf("Expected no problem but got %v", problems)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package cfn
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+// Actions planned by the dry runs
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+	PlanSkip   = "skip"
+	// PlanBlocked is planned when the stack can't be planned yet, e.g. its referenced outputs don't exist
+	PlanBlocked = "blocked"
+	// PlanDeclined is returned by ApplyChangeSet when the change set isn't approved
+	PlanDeclined = "declined"
+)
+
+// ResourceChange is a resource change reported by a change set
+type ResourceChange struct {
+	// Action is one of Add, Modify, Remove, Import, Dynamic
+	Action             string   `json:"action"`
+	LogicalResourceID  string   `json:"logical_resource_id"`
+	PhysicalResourceID string   `json:"physical_resource_id,omitempty"`
+	ResourceType       string   `json:"resource_type"`
+	Replacement        string   `json:"replacement,omitempty"`
+	Scope              []string `json:"scope,omitempty"`
+}
+
+// StackPlan is the outcome of a dry run: what would happen to the stack and its resources
+type StackPlan struct {
+	StackName string           `json:"stack_name"`
+	Status    string           `json:"status"`
+	Action    string           `json:"action"`
+	Reason    string           `json:"reason,omitempty"`
+	Changes   []ResourceChange `json:"changes,omitempty"`
+	// The change set and the stack state are only recorded when the change set is kept to apply the plan later
+	ChangeSetID     string     `json:"change_set_id,omitempty"`
+	ChangeSetName   string     `json:"change_set_name,omitempty"`
+	StackID         string     `json:"stack_id,omitempty"`
+	LastUpdatedTime *time.Time `json:"last_updated_time,omitempty"`
+}
+
+// Replacements returns the number of resource changes that replace the resource, conditional ones included
+func (p StackPlan) Replacements() int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Replacement == "True" || c.Replacement == "Conditional" {
+			n++
+		}
+	}
+	return n
+}
+
+// Approver decides whether the change set of the plan is executed, a nil Approver approves every change set
+type Approver func(ctx context.Context, plan StackPlan) (bool, error)
+
+// ResourceChanges returns every resource change of the change set, following the pages
+func (cm CFNManager) ResourceChanges(ctx context.Context, stackName string, changeSetName string) ([]ResourceChange, error) {
+	input := &cloudformation.DescribeChangeSetInput{
+		ChangeSetName: aws.String(changeSetName),
+		StackName:     aws.String(stackName),
+	}
+
+	var changes []ResourceChange
+	for {
+		var res *cloudformation.DescribeChangeSetOutput
+		err := cm.retry(ctx, "DescribeChangeSet", func() (err error) {
+			res, err = cm.Client.DescribeChangeSet(input)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range res.Changes {
+			if c.ResourceChange != nil {
+				changes = append(changes, newResourceChange(c.ResourceChange))
+			}
+		}
+
+		if res.NextToken == nil {
+			return changes, nil
+		}
+		input.NextToken = res.NextToken
+	}
+}
+
+func newResourceChange(rc *cloudformation.ResourceChange) ResourceChange {
+	return ResourceChange{
+		Action:             aws.StringValue(rc.Action),
+		LogicalResourceID:  aws.StringValue(rc.LogicalResourceId),
+		PhysicalResourceID: aws.StringValue(rc.PhysicalResourceId),
+		ResourceType:       aws.StringValue(rc.ResourceType),
+		Replacement:        aws.StringValue(rc.Replacement),
+		Scope:              aws.StringValueSlice(rc.Scope),
+	}
+}
+
+// changeSetLink returns the console link of the change set, in the region of the manager
+func (cm CFNManager) changeSetLink(stackID string, changeSetID string) string {
+	region := cm.Region
+	if region == "" {
+		region = defaultRegion
+	}
+	return fmt.Sprintf("https://%s.console.aws.amazon.com/cloudformation/home?region=%s#/stacks/changesets/changes?stackId=%s&changeSetId=%s", region, region, url.QueryEscape(stackID), url.QueryEscape(changeSetID))
+}
+
+/*
+ApplyPlan executes the change set recorded by PlanChanges. It refuses to proceed when the stack changed since the plan
+was made, or when the change set can no longer be executed. It returns the action taken, the one of the plan.
+*/
+func (s *Stack) ApplyPlan(ctx context.Context, cm CFNManager, plan StackPlan) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	switch plan.Action {
+	case PlanSkip:
+		logger.Log.InfoCtxf(ctx, "Skipping... as no change is planned.\n")
+		return PlanSkip, nil
+	case PlanCreate, PlanUpdate:
+	default:
+		return "", fmt.Errorf("Stopping... the plan of the stack: %s can't be applied as its action is %s", s.StackName, plan.Action)
+	}
+
+	if plan.ChangeSetID == "" {
+		return "", fmt.Errorf("Stopping... no change set is recorded in the plan of the stack: %s, run the dry run with --keep-changesets", s.StackName)
+	}
+
+	res, err := cm.DescribeStacks(ctx, s.StackName)
+	if err != nil {
+		return "", fmt.Errorf("Stopping... failed while checking the stack: %s, ERROR: %w", s.StackName, err)
+	}
+	current := res.Stacks[0]
+
+	expectedStatus := plan.Status
+	if plan.Action == PlanCreate {
+		expectedStatus = "REVIEW_IN_PROGRESS"
+	}
+	if aws.StringValue(current.StackId) != plan.StackID || aws.StringValue(current.StackStatus) != expectedStatus || !sameTime(current.LastUpdatedTime, plan.LastUpdatedTime) {
+		return "", fmt.Errorf("Stopping... the stack: %s changed since the plan was made, status: %s, last updated: %s. Run the dry run again", s.StackName, aws.StringValue(current.StackStatus), formatTime(current.LastUpdatedTime))
+	}
+
+	cs, err := cm.DescribeChangeSet(ctx, s.StackName, plan.ChangeSetID)
+	if err != nil {
+		return "", fmt.Errorf("Stopping... failed while checking the change set: %s, ERROR: %w", plan.ChangeSetName, err)
+	}
+	if aws.StringValue(cs.Status) != "CREATE_COMPLETE" || aws.StringValue(cs.ExecutionStatus) != "AVAILABLE" {
+		return "", fmt.Errorf("Stopping... the change set: %s is no longer valid, status: %s, execution status: %s. Run the dry run again", plan.ChangeSetName, aws.StringValue(cs.Status), aws.StringValue(cs.ExecutionStatus))
+	}
+
+	logger.Log.InfoCtxf(ctx, "Executing ChangeSet... %s with %d resource change(s).\n", plan.ChangeSetName, len(plan.Changes))
+	_, err = cm.ExecuteChangeSetWithWait(ctx, &cloudformation.ExecuteChangeSetInput{
+		ChangeSetName: aws.String(plan.ChangeSetID),
+		StackName:     aws.String(s.StackName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return plan.Action, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+/*
+ApplyChangeSet deploys the stack like ApplyChanges but updates are performed through a change set,
+executed once approved. Change sets without changes or not approved are deleted. It returns the action taken
+like ApplyChanges, or PlanDeclined when the change set isn't approved.
+*/
+func (s *Stack) ApplyChangeSet(ctx context.Context, cm CFNManager, approve Approver) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	status, err := s.status(ctx, cm)
+	if err != nil {
+		return "", err
+	}
+
+	status, err = s.recover(ctx, cm, status, true)
+	if err != nil {
+		return "", err
+	}
+
+	switch status {
+	case "UPDATE_FAILED", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_COMPLETE", "CREATE_COMPLETE":
+	default:
+		return s.applyChanges(ctx, cm, status)
+	}
+
+	logger.Log.InfoCtxf(ctx, "Creating ChangeSet... as the stack is in %s state.\n", status)
+	plan := StackPlan{StackName: s.StackName, Status: status, Action: PlanUpdate}
+	changed, err := s.planChangeSet(ctx, cm, &plan, "UPDATE", true)
+	if err != nil {
+		return "", err
+	}
+	if !changed {
+		logger.Log.InfoCtxf(ctx, "Skipping... Update as no change is detected.\n")
+		return PlanSkip, nil
+	}
+
+	if approve != nil {
+		approved, err := approve(ctx, plan)
+		if err != nil {
+			s.deleteChangeSet(ctx, cm, plan.ChangeSetName)
+			return "", err
+		}
+		if !approved {
+			logger.Log.WarnCtxf(ctx, "Skipping... Update as the change set: %s is not approved.\n", plan.ChangeSetName)
+			s.deleteChangeSet(ctx, cm, plan.ChangeSetName)
+			return PlanDeclined, nil
+		}
+	}
+
+	logger.Log.InfoCtxf(ctx, "Executing ChangeSet... %s with %d resource change(s).\n", plan.ChangeSetName, len(plan.Changes))
+	_, err = cm.ExecuteChangeSetWithWait(ctx, &cloudformation.ExecuteChangeSetInput{
+		ChangeSetName: aws.String(plan.ChangeSetID),
+		StackName:     aws.String(s.StackName),
+	})
+	if err != nil {
+		return "", err
+	}
+	logger.Log.InfoCtxf(ctx, "Update Completed.")
+	return PlanUpdate, nil
+}
@@ -0,0 +1,273 @@
+package cfn
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+)
+
+func TestApplyPlan(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the planned change set of a new stack is applied")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		plan, err := s.PlanChanges(ctx, cm, true)
+		if err != nil {
+			t.Fatalf("PlanChanges should return nil but found error: %s", err)
+		}
+		if plan.Action != PlanCreate || plan.ChangeSetID == "" || plan.StackID == "" {
+			t.Fatalf("Expected a create plan with a change set but got %+v", plan)
+		}
+		if status := fake.StackStatus("s1"); status != "REVIEW_IN_PROGRESS" {
+			t.Fatalf("Expected stack status to be REVIEW_IN_PROGRESS but got %s", status)
+		}
+
+		if _, err := s.ApplyPlan(ctx, cm, plan); err != nil {
+			t.Fatalf("ApplyPlan should return nil but found error: %s", err)
+		}
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the planned new stack is destroyed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.PlanChanges(ctx, cm, true); err != nil {
+			t.Fatalf("PlanChanges should return nil but found error: %s", err)
+		}
+
+		plan, err := s.DestroyDryRun(ctx, cm)
+		if err != nil || plan.Action != PlanDelete {
+			t.Fatalf("Expected the dry run to delete the stack but got %+v, %v", plan, err)
+		}
+
+		action, err := s.Destroy(ctx, cm)
+		if err != nil || action != PlanDelete {
+			t.Fatalf("Expected the stack to be deleted but got %s, %v", action, err)
+		}
+		if status := fake.StackStatus("s1"); status != "DOESN'T EXIST" {
+			t.Fatalf("Expected the stack to be deleted but got %s", status)
+		}
+	}
+
+	t.Log("When the planned new stack is deployed without its plan")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		plan, err := s.PlanChanges(ctx, cm, true)
+		if err != nil {
+			t.Fatalf("PlanChanges should return nil but found error: %s", err)
+		}
+
+		action, err := s.ApplyChanges(ctx, cm)
+		if err != nil || action != PlanCreate {
+			t.Fatalf("Expected the stack to be created but got %s, %v", action, err)
+		}
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+
+		// the planned change set is obsolete once another one created the stack
+		_, err = cm.ExecuteChangeSetWithWait(ctx, &cloudformation.ExecuteChangeSetInput{ChangeSetName: aws.String(plan.ChangeSetID), StackName: aws.String("s1")})
+		if err == nil || !strings.Contains(err.Error(), "InvalidChangeSetStatus") {
+			t.Fatalf("Expected the obsolete change set not to be executed but got %v", err)
+		}
+	}
+
+	t.Log("When the stack changed since the plan was made")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		s.Parameters = map[string]string{"Env": "prod"}
+		plan, err := s.PlanChanges(ctx, cm, true)
+		if err != nil {
+			t.Fatalf("PlanChanges should return nil but found error: %s", err)
+		}
+		if plan.Action != PlanUpdate || plan.ChangeSetID == "" {
+			t.Fatalf("Expected an update plan with a change set but got %+v", plan)
+		}
+
+		drifted := Stack{StackName: "s1", TemplateFile: s.TemplateFile, Parameters: map[string]string{"Env": "dev"}}
+		if _, err := drifted.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		_, err = s.ApplyPlan(ctx, cm, plan)
+		if err == nil || !strings.Contains(err.Error(), "changed since the plan was made") {
+			t.Fatalf("Expected ApplyPlan to refuse the drifted stack but got %v", err)
+		}
+		for _, call := range fake.Calls() {
+			if strings.HasPrefix(call, "ExecuteChangeSet") {
+				t.Fatalf("Expected the change set not to be executed but got calls %v", fake.Calls())
+			}
+		}
+	}
+
+	t.Log("When the planned change set was deleted")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		s.Parameters = map[string]string{"Env": "prod"}
+		plan, err := s.PlanChanges(ctx, cm, true)
+		if err != nil {
+			t.Fatalf("PlanChanges should return nil but found error: %s", err)
+		}
+		if _, err := cm.DeleteChangeSet(ctx, "s1", plan.ChangeSetID); err != nil {
+			t.Fatalf("DeleteChangeSet should return nil but found error: %s", err)
+		}
+
+		if _, err := s.ApplyPlan(ctx, cm, plan); err == nil {
+			t.Fatal("ApplyPlan should return error but found nil")
+		}
+	}
+
+	t.Log("When the plan has no change set recorded")
+	{
+		cm := CFNManager{Client: cfntest.NewFakeCloudFormation()}
+		s := Stack{StackName: "s1"}
+
+		if _, err := s.ApplyPlan(ctx, cm, StackPlan{StackName: "s1", Action: PlanUpdate}); err == nil {
+			t.Fatal("ApplyPlan should return error but found nil")
+		}
+		if _, err := s.ApplyPlan(ctx, cm, StackPlan{StackName: "s1", Action: PlanSkip}); err != nil {
+			t.Fatalf("ApplyPlan should skip the stack but found error: %s", err)
+		}
+	}
+}
+
+func TestApplyChangeSet(t *testing.T) {
+	ctx := context.Background()
+	approve := func(approved bool) Approver {
+		return func(ctx context.Context, plan StackPlan) (bool, error) {
+			return approved, nil
+		}
+	}
+
+	t.Log("When the change set is approved")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChangeSet(ctx, cm, approve(true)); err != nil {
+			t.Fatalf("ApplyChangeSet should return nil but found error: %s", err)
+		}
+
+		s.Parameters = map[string]string{"Env": "prod"}
+		if _, err := s.ApplyChangeSet(ctx, cm, approve(true)); err != nil {
+			t.Fatalf("ApplyChangeSet should return nil but found error: %s", err)
+		}
+
+		calls := fake.Calls()
+		if calls[len(calls)-1] != "ExecuteChangeSet:s1" {
+			t.Fatalf("Expected the change set to be executed but got calls %v", calls)
+		}
+		if _, params := fake.StackInput("s1"); params["Env"] != "prod" {
+			t.Fatalf("Expected parameter Env to be prod but got %s", params["Env"])
+		}
+	}
+
+	t.Log("When the change set is not approved")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "CREATE_COMPLETE")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Parameters: map[string]string{"Env": "prod"}}
+
+		action, err := s.ApplyChangeSet(ctx, cm, approve(false))
+		if err != nil {
+			t.Fatalf("ApplyChangeSet should return nil but found error: %s", err)
+		}
+		if action != PlanDeclined {
+			t.Fatalf("Expected the action to be %s but got %s", PlanDeclined, action)
+		}
+
+		calls := fake.Calls()
+		if calls[len(calls)-1] != "DeleteChangeSet:s1" {
+			t.Fatalf("Expected the change set to be deleted but got calls %v", calls)
+		}
+	}
+
+	t.Log("When the change set has no changes")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		asked := false
+		_, err := s.ApplyChangeSet(ctx, cm, func(ctx context.Context, plan StackPlan) (bool, error) {
+			asked = true
+			return true, nil
+		})
+		if err != nil {
+			t.Fatalf("ApplyChangeSet should return nil but found error: %s", err)
+		}
+
+		calls := fake.Calls()
+		if asked || calls[len(calls)-1] != "DeleteChangeSet:s1" {
+			t.Fatalf("Expected the empty change set to be deleted without approval but got calls %v", calls)
+		}
+	}
+	t.Log("When the failed new stack is recreated")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "ROLLBACK_COMPLETE")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Recovery: RecoveryPolicy{RollbackComplete: RecoveryRecreate}}
+
+		action, err := s.ApplyChangeSet(ctx, cm, approve(true))
+		if err != nil || action != PlanCreate {
+			t.Fatalf("Expected the stack to be created but got %s, %v", action, err)
+		}
+
+		calls := fake.Calls()
+		expected := []string{"DeleteStack:s1", "CreateStack:s1"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected the stack to be recovered once with calls %v but got %v", expected, calls)
+		}
+	}
+
+	t.Log("When the change set fails for another reason than having no changes")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "CREATE_COMPLETE")
+		fake.FailChangeSet("s1", "Transform AWS::Serverless-2016-10-31 failed with: Invalid Serverless Application Specification document.")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Parameters: map[string]string{"Env": "prod"}}
+
+		action, err := s.ApplyChangeSet(ctx, cm, approve(true))
+		if err == nil || !strings.Contains(err.Error(), "Invalid Serverless Application") {
+			t.Fatalf("Expected the failure reason of the change set but got %s, %v", action, err)
+		}
+		if action == PlanSkip {
+			t.Fatal("Expected the failed change set not to be skipped")
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package cfn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+// Recovery actions
+const (
+	RecoveryFail     = "fail"
+	RecoveryRecreate = "recreate"
+	RecoveryContinue = "continue"
+	RecoveryWait     = "wait"
+)
+
+// defaultInProgressTimeout bounds the wait for an operation of the stack started by someone else
+const defaultInProgressTimeout = 60 * time.Minute
+
+/*
+RecoveryPolicy defines how a stack is brought back into a deployable state instead of failing the deployment.
+Every action defaults to fail, except the operations in progress which are waited for.
+*/
+type RecoveryPolicy struct {
+	// RollbackComplete is the action taken when the stack failed its first create: fail or recreate
+	RollbackComplete string `yaml:"rollback_complete,omitempty"`
+	// UpdateRollbackFailed is the action taken when the stack failed to roll back an update: fail or continue
+	UpdateRollbackFailed string `yaml:"update_rollback_failed,omitempty"`
+	// ResourcesToSkip are the logical ids of the resources skipped when the update rollback is continued
+	ResourcesToSkip []string `yaml:"resources_to_skip,omitempty"`
+	// InProgress is the action taken when another operation of the stack is in progress: wait (default) or fail
+	InProgress string `yaml:"in_progress,omitempty"`
+	// InProgressTimeout is the maximum time waited for the operation in progress, e.g. 30m, defaults to 60m
+	InProgressTimeout time.Duration `yaml:"in_progress_timeout,omitempty"`
+}
+
+func (p RecoveryPolicy) Validate() error {
+	checks := []struct {
+		name    string
+		value   string
+		allowed []string
+	}{
+		{"rollback_complete", p.RollbackComplete, []string{RecoveryFail, RecoveryRecreate}},
+		{"update_rollback_failed", p.UpdateRollbackFailed, []string{RecoveryFail, RecoveryContinue}},
+		{"in_progress", p.InProgress, []string{RecoveryFail, RecoveryWait}},
+	}
+
+	for _, c := range checks {
+		if c.value == "" {
+			continue
+		}
+		valid := false
+		for _, a := range c.allowed {
+			valid = valid || c.value == a
+		}
+		if !valid {
+			return fmt.Errorf("recovery %s should be one of %s, found: %s", c.name, strings.Join(c.allowed, ", "), c.value)
+		}
+	}
+
+	if len(p.ResourcesToSkip) > 0 && p.UpdateRollbackFailed != RecoveryContinue {
+		return fmt.Errorf("recovery resources_to_skip requires update_rollback_failed: %s", RecoveryContinue)
+	}
+
+	if p.InProgressTimeout < 0 {
+		return fmt.Errorf("recovery in_progress_timeout can't be negative, found: %s", p.InProgressTimeout)
+	}
+
+	return nil
+}
+
+// inProgress reports whether an operation of the stack is in progress, REVIEW_IN_PROGRESS stacks wait for a change set execution
+func inProgress(status string) bool {
+	return strings.HasSuffix(status, "_IN_PROGRESS") && status != "REVIEW_IN_PROGRESS"
+}
+
+/*
+recover applies the recovery policy of the stack to its current status and returns the status once recovered.
+The stacks being deleted are only waited for, the other recoveries only make sense before a deployment.
+*/
+func (s *Stack) recover(ctx context.Context, cm CFNManager, status string, deploy bool) (string, error) {
+	for {
+		switch {
+		case inProgress(status) && s.Recovery.InProgress != RecoveryFail:
+			timeout := s.Recovery.InProgressTimeout
+			if timeout <= 0 {
+				timeout = defaultInProgressTimeout
+			}
+			logger.Log.InfoCtxf(ctx, "Waiting... up to %s as the stack is in %s state. %s\n", timeout, status, cm.describeOperation(ctx, s.StackName))
+
+			wctx, cancel := context.WithTimeout(ctx, timeout)
+			var err error
+			status, err = cm.waitStackStable(wctx, s.StackName)
+			if err != nil {
+				if terr := cm.timeoutError(wctx, "InProgress", s.StackName); terr != nil {
+					err = terr
+				}
+				cancel()
+				return status, err
+			}
+			cancel()
+			logger.Log.InfoCtxf(ctx, "Resuming... as the operation in progress completed with %s state.\n", status)
+
+		case deploy && status == "ROLLBACK_COMPLETE" && s.Recovery.RollbackComplete == RecoveryRecreate:
+			logger.Log.InfoCtxf(ctx, "Deleting Stack... to create it again as the stack is in %s state.\n", status)
+			i, err := s.deleteStackInput()
+			if err != nil {
+				return status, err
+			}
+			if _, err := cm.DeleteStackWithWait(ctx, &i); err != nil {
+				return status, err
+			}
+			return "DELETE_COMPLETE", nil
+
+		case deploy && status == "UPDATE_ROLLBACK_FAILED" && s.Recovery.UpdateRollbackFailed == RecoveryContinue:
+			logger.Log.InfoCtxf(ctx, "Continuing Update Rollback... as the stack is in %s state. Resources to skip: %v\n", status, s.Recovery.ResourcesToSkip)
+			input := cloudformation.ContinueUpdateRollbackInput{StackName: aws.String(s.StackName)}
+			if len(s.Recovery.ResourcesToSkip) > 0 {
+				input.ResourcesToSkip = aws.StringSlice(s.Recovery.ResourcesToSkip)
+			}
+			if err := cm.ContinueUpdateRollbackWithWait(ctx, &input); err != nil {
+				return status, err
+			}
+			return "UPDATE_ROLLBACK_COMPLETE", nil
+
+		default:
+			return status, nil
+		}
+	}
+}
+
+// waitStackStable polls the stack until no operation is in progress and returns its status
+func (cm CFNManager) waitStackStable(ctx context.Context, stackName string) (string, error) {
+	interval := cm.EventPollInterval
+	if interval <= 0 {
+		interval = defaultEventPollInterval
+	}
+
+	for {
+		res, err := cm.DescribeStacks(ctx, stackName)
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ValidationError" {
+				return "DOESN'T EXIST", nil
+			}
+			return "", err
+		}
+
+		status := aws.StringValue(res.Stacks[0].StackStatus)
+		if !inProgress(status) {
+			return status, nil
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return status, ctx.Err()
+		}
+	}
+}
+
+/*
+describeOperation tells who started the operation in progress, from the latest "User Initiated" event of the stack.
+The ClientRequestToken of the event identifies the operations started by cfn-compose and from the console.
+*/
+func (cm CFNManager) describeOperation(ctx context.Context, stackName string) string {
+	res, err := cm.DescribeStackEvents(ctx, stackName, nil)
+	if err != nil || len(res.StackEvents) == 0 {
+		return "The operation in progress is unknown."
+	}
+
+	start := res.StackEvents[0]
+	for _, e := range res.StackEvents {
+		if stackID(e) == aws.StringValue(e.PhysicalResourceId) && aws.StringValue(e.ResourceStatusReason) == "User Initiated" {
+			start = e
+			break
+		}
+	}
+
+	return fmt.Sprintf("The operation %s was started at %s by %s.", aws.StringValue(start.ResourceStatus), formatTime(start.Timestamp), initiator(aws.StringValue(start.ClientRequestToken)))
+}
+
+// initiator describes the origin of an operation from its ClientRequestToken
+func initiator(token string) string {
+	switch {
+	case token == "":
+		return "a request without ClientRequestToken"
+	case strings.HasPrefix(token, "cfnc-"):
+		return "cfn-compose (token: " + token + ")"
+	case strings.HasPrefix(token, "Console-"):
+		return "the AWS console (token: " + token + ")"
+	default:
+		return "a request with ClientRequestToken: " + token
+	}
+}
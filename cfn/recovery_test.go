@@ -0,0 +1,194 @@
+package cfn
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+)
+
+func TestRecoveryPolicy(t *testing.T) {
+	t.Log("When validating the recovery policies")
+	{
+		valid := []RecoveryPolicy{
+			{},
+			{RollbackComplete: RecoveryRecreate, InProgress: RecoveryWait},
+			{UpdateRollbackFailed: RecoveryContinue, ResourcesToSkip: []string{"Queue"}},
+			{InProgress: RecoveryFail, InProgressTimeout: time.Minute},
+		}
+		for _, p := range valid {
+			if err := p.Validate(); err != nil {
+				t.Fatalf("Validation should return nil for %+v but found error: %s", p, err)
+			}
+		}
+
+		invalid := []RecoveryPolicy{
+			{RollbackComplete: "continue"},
+			{InProgress: "retry"},
+			{ResourcesToSkip: []string{"Queue"}},
+			{InProgressTimeout: -time.Minute},
+		}
+		for _, p := range invalid {
+			if err := p.Validate(); err == nil {
+				t.Fatalf("Validation should return error for %+v but found nil", p)
+			}
+		}
+	}
+}
+
+func TestApplyChangesRecovery(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the stack is in ROLLBACK_COMPLETE state without recovery")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "ROLLBACK_COMPLETE")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.ApplyChanges(ctx, cm); err == nil {
+			t.Fatal("ApplyChanges should return error but found nil")
+		}
+	}
+
+	t.Log("When the stack is in ROLLBACK_COMPLETE state and recreated")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "ROLLBACK_COMPLETE")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Recovery: RecoveryPolicy{RollbackComplete: RecoveryRecreate}}
+
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		calls := fake.Calls()
+		if len(calls) != 2 || calls[0] != "DeleteStack:s1" || calls[1] != "CreateStack:s1" {
+			t.Fatalf("Expected the stack to be deleted and created but got calls %v", calls)
+		}
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the stack is in UPDATE_ROLLBACK_FAILED state and the rollback is continued")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "UPDATE_ROLLBACK_FAILED")
+		cm := CFNManager{Client: fake, EventPollInterval: time.Millisecond}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Recovery: RecoveryPolicy{UpdateRollbackFailed: RecoveryContinue, ResourcesToSkip: []string{"Queue"}}}
+
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		calls := fake.Calls()
+		if len(calls) != 2 || calls[0] != "ContinueUpdateRollback:s1" || calls[1] != "UpdateStack:s1" {
+			t.Fatalf("Expected the rollback to be continued before the update but got calls %v", calls)
+		}
+	}
+
+	t.Log("When another operation of the stack is in progress")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.StartOperation("s1", "UPDATE_IN_PROGRESS", "Console-UpdateStack-1", 3)
+		cm := CFNManager{Client: fake, EventPollInterval: time.Millisecond}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		calls := fake.Calls()
+		if len(calls) != 1 || calls[0] != "UpdateStack:s1" {
+			t.Fatalf("Expected the stack to be updated once the operation completed but got calls %v", calls)
+		}
+	}
+
+	t.Log("When another operation of the stack is in progress and the recovery policy is fail")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "UPDATE_IN_PROGRESS")
+		cm := CFNManager{Client: fake, EventPollInterval: time.Millisecond}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Recovery: RecoveryPolicy{InProgress: RecoveryFail}}
+
+		if _, err := s.ApplyChanges(ctx, cm); err == nil {
+			t.Fatal("ApplyChanges should return error but found nil")
+		}
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no mutating calls but got %v", fake.Calls())
+		}
+	}
+
+	t.Log("When the operation in progress doesn't complete before the timeout")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.StartOperation("s1", "UPDATE_IN_PROGRESS", "", 1000)
+		cm := CFNManager{Client: fake, EventPollInterval: time.Millisecond}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Recovery: RecoveryPolicy{InProgressTimeout: 20 * time.Millisecond}}
+
+		_, err := s.ApplyChanges(ctx, cm)
+		var terr *TimeoutError
+		if !errors.As(err, &terr) || terr.Status != "UPDATE_IN_PROGRESS" {
+			t.Fatalf("Expected ApplyChanges to time out but got %v", err)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no mutating calls but got %v", fake.Calls())
+		}
+	}
+
+	t.Log("When the stack being deleted by another operation is destroyed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "DELETE_IN_PROGRESS")
+		cm := CFNManager{Client: fake, EventPollInterval: time.Millisecond}
+		s := Stack{StackName: "s1", Recovery: RecoveryPolicy{InProgress: RecoveryWait}}
+
+		if _, err := s.Destroy(ctx, cm); err != nil {
+			t.Fatalf("Destroy should return nil but found error: %s", err)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no mutating calls but got %v", fake.Calls())
+		}
+	}
+}
+
+func TestDescribeOperation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the operation was started from the console")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.StartOperation("s1", "UPDATE_IN_PROGRESS", "Console-UpdateStack-1", 1)
+		cm := CFNManager{Client: fake}
+
+		if op := cm.describeOperation(ctx, "s1"); !strings.Contains(op, "UPDATE_IN_PROGRESS") || !strings.Contains(op, "the AWS console") {
+			t.Fatalf("Expected the console update to be described but got %s", op)
+		}
+	}
+
+	t.Log("When the last operation was started by cfn-compose")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		if op := cm.describeOperation(ctx, "s1"); !strings.Contains(op, "CREATE_IN_PROGRESS") || !strings.Contains(op, "cfn-compose (token: cfnc-create-s1-") {
+			t.Fatalf("Expected the cfn-compose create to be described but got %s", op)
+		}
+	}
+
+	t.Log("When the stack doesn't exist")
+	{
+		cm := CFNManager{Client: cfntest.NewFakeCloudFormation()}
+		if op := cm.describeOperation(ctx, "s1"); !strings.Contains(op, "unknown") {
+			t.Fatalf("Expected the operation to be unknown but got %s", op)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package cfn
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = 30 * time.Second
+)
+
+// Error codes returned when the CloudFormation API rate limit is hit
+var throttlingErrors = []string{"Throttling", "ThrottlingException", "ThrottledException", "RequestLimitExceeded", "RequestThrottled", "RequestThrottledException", "TooManyRequestsException"}
+
+// Error codes of transient network and service failures
+var transientErrors = []string{"RequestError", "RequestTimeout", "RequestTimeoutException", "ResponseTimeout", "InternalFailure", "InternalError", "ServiceUnavailable"}
+
+/*
+RetryPolicy defines how the throttled and transient CloudFormation errors are retried.
+Every failed attempt waits for a random delay between 0 and min(MaxDelay, BaseDelay * 2^attempt).
+Zero values fall back to the defaults: 5 attempts, 1s base delay and 30s max delay.
+*/
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"MaxAttempts,omitempty"`
+	BaseDelay   time.Duration `yaml:"BaseDelay,omitempty"`
+	MaxDelay    time.Duration `yaml:"MaxDelay,omitempty"`
+	// RetryableErrors are additional error codes to retry
+	RetryableErrors []string `yaml:"RetryableErrors,omitempty"`
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("Retry MaxAttempts should be >= 0, found: %d", p.MaxAttempts)
+	}
+
+	if p.BaseDelay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("Retry BaseDelay and MaxDelay should be >= 0, found: %s and %s", p.BaseDelay, p.MaxDelay)
+	}
+
+	if p.BaseDelay > 0 && p.MaxDelay > 0 && p.BaseDelay > p.MaxDelay {
+		return fmt.Errorf("Retry BaseDelay %s should be <= MaxDelay %s", p.BaseDelay, p.MaxDelay)
+	}
+
+	return nil
+}
+
+// Merge returns the policy overridden by the non zero values of the other policy
+func (p RetryPolicy) Merge(o RetryPolicy) RetryPolicy {
+	if o.MaxAttempts > 0 {
+		p.MaxAttempts = o.MaxAttempts
+	}
+	if o.BaseDelay > 0 {
+		p.BaseDelay = o.BaseDelay
+	}
+	if o.MaxDelay > 0 {
+		p.MaxDelay = o.MaxDelay
+	}
+	p.RetryableErrors = append(append([]string{}, p.RetryableErrors...), o.RetryableErrors...)
+	return p
+}
+
+func (p RetryPolicy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// Retryable reports whether the error is a throttling or transient error worth retrying
+func (p RetryPolicy) Retryable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+
+	if rerr, ok := err.(awserr.RequestFailure); ok && rerr.StatusCode() >= 500 {
+		return true
+	}
+
+	for _, codes := range [][]string{throttlingErrors, transientErrors, p.RetryableErrors} {
+		for _, code := range codes {
+			if aerr.Code() == code {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Backoff returns the delay before the given retry attempt, starting from 0
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = defaultBaseDelay
+	}
+	max := p.MaxDelay
+	if max <= 0 {
+		max = defaultMaxDelay
+	}
+
+	delay := max
+	if attempt < 32 && base<<uint(attempt) > 0 && base<<uint(attempt) < max {
+		delay = base << uint(attempt)
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// retry calls fn until it succeeds, returns a non retryable error or the attempts are exhausted
+func (cm CFNManager) retry(ctx context.Context, operation string, fn func() error) error {
+	attempts := cm.Retry.maxAttempts()
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		err = fn()
+		if err == nil || !cm.Retry.Retryable(err) || attempt == attempts-1 {
+			return err
+		}
+
+		delay := cm.Retry.Backoff(attempt)
+		logger.Log.WarnCtxf(ctx, "%s failed with a retryable error, retrying in %s (attempt %d/%d): %s\n", operation, delay.Round(time.Millisecond), attempt+1, attempts, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,117 @@
+package cfn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	t.Log("When classifying errors")
+	{
+		p := RetryPolicy{RetryableErrors: []string{"LimitExceededException"}}
+		cases := []struct {
+			err       error
+			retryable bool
+		}{
+			{awserr.New("Throttling", "Rate exceeded", nil), true},
+			{awserr.New("RequestLimitExceeded", "Request limit exceeded", nil), true},
+			{awserr.New("RequestError", "send request failed", nil), true},
+			{awserr.NewRequestFailure(awserr.New("InternalFailure", "", nil), 500, ""), true},
+			{awserr.NewRequestFailure(awserr.New("Unknown", "", nil), 503, ""), true},
+			{awserr.New("LimitExceededException", "", nil), true},
+			{awserr.New("ValidationError", "No updates are to be performed.", nil), false},
+			{awserr.New("ResourceNotReady", "failed waiting for successful resource state", nil), false},
+			{errors.New("plain error"), false},
+		}
+
+		for _, c := range cases {
+			if p.Retryable(c.err) != c.retryable {
+				t.Fatalf("Expected retryable to be %t for %s", c.retryable, c.err)
+			}
+		}
+	}
+
+	t.Log("When computing the backoff")
+	{
+		p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+		for attempt := 0; attempt < 40; attempt++ {
+			limit := time.Second
+			if attempt < 4 {
+				limit = (100 * time.Millisecond) << uint(attempt)
+			}
+			if d := p.Backoff(attempt); d < 0 || d > limit {
+				t.Fatalf("Expected backoff of attempt %d to be within [0, %s] but got %s", attempt, limit, d)
+			}
+		}
+	}
+
+	t.Log("When merging policies")
+	{
+		p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}.Merge(RetryPolicy{MaxAttempts: 8})
+		if p.MaxAttempts != 8 || p.BaseDelay != time.Second {
+			t.Fatalf("Expected MaxAttempts 8 and BaseDelay 1s but got %+v", p)
+		}
+	}
+
+	t.Log("When validating an invalid policy")
+	{
+		invalid := []RetryPolicy{{MaxAttempts: -1}, {BaseDelay: -time.Second}, {BaseDelay: time.Minute, MaxDelay: time.Second}}
+		for _, p := range invalid {
+			if err := p.Validate(); err == nil {
+				t.Fatalf("Validation should return error for %+v but found nil", p)
+			}
+		}
+	}
+}
+
+func TestCFNManagerRetry(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	t.Log("When the calls are throttled less than the max attempts")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Throttle("DescribeStacks", 2)
+		fake.Throttle("CreateStack", 2)
+		cm := CFNManager{Client: fake, Retry: policy}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the calls are throttled more than the max attempts")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Throttle("DescribeStacks", 3)
+		cm := CFNManager{Client: fake, Retry: policy}
+
+		_, err := cm.DescribeStacks(ctx, "s1")
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "Throttling" {
+			t.Fatalf("Expected Throttling error but got %v", err)
+		}
+	}
+
+	t.Log("When the error is not retryable")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Throttle("DescribeStacks", 1)
+		cm := CFNManager{Client: fake, Retry: policy}
+
+		// first attempt is throttled, the second returns the ValidationError of a missing stack
+		_, err := cm.DescribeStacks(ctx, "s1")
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "ValidationError" {
+			t.Fatalf("Expected ValidationError error but got %v", err)
+		}
+	}
+}
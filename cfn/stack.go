@@ -6,11 +6,11 @@ import (
 	"fmt"
 	"github.com/rbalman/cfn-compose/libs"
 	"github.com/rbalman/cfn-compose/logger"
-	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 )
@@ -23,9 +23,14 @@ type Stack struct {
 	StackName    string            `yaml:"stack_name"`
 	Capabilities []string          `yaml:"capabilities,omitempty"`
 	Parameters   map[string]string `yaml:"parameters,omitempty"`
-	// ParametersFile   string            `yaml:"parameters_file"`
+	// ParametersFile holds parameters overridden by the inline ones, see ReadParametersFile for its formats
+	ParametersFile string `yaml:"parameters_file,omitempty"`
+	// UsePreviousValue lists the parameters keeping their current value on updates, e.g. secrets not in the compose file
+	UsePreviousValue []string          `yaml:"use_previous_value,omitempty"`
 	Tags             map[string]string `yaml:"tags,omitempty"`
 	TimeoutInMinutes int64             `yaml:"timeout,omitempty"`
+	DependsOn        []string          `yaml:"depends_on,omitempty"`
+	Recovery         RecoveryPolicy    `yaml:"recovery,omitempty"`
 	cm               CFNManager
 }
 
@@ -33,6 +38,9 @@ type Stack struct {
 Stack is valid only when it satisfies all the below mentioned conditions:
 - stack_name can't be empty
 - one of template_url or template_file is mandatory, if both provided results into error
+- timeout, in minutes, can't be negative
+- use_previous_value can't hold an empty key
+- the recovery policy is valid
 */
 func (s *Stack) Validate(index int) error {
 	if s.StackName == "" {
@@ -47,6 +55,20 @@ func (s *Stack) Validate(index int) error {
 		return fmt.Errorf("can't provide value for both 'template_file' and 'template_url' property for %d index stack", index)
 	}
 
+	if s.TimeoutInMinutes < 0 {
+		return fmt.Errorf("timeout property for %d index stack can't be negative, found: %d", index, s.TimeoutInMinutes)
+	}
+
+	for _, k := range s.UsePreviousValue {
+		if k == "" {
+			return fmt.Errorf("use_previous_value property for %d index stack can't hold an empty parameter key", index)
+		}
+	}
+
+	if err := s.Recovery.Validate(); err != nil {
+		return fmt.Errorf("%s for %d index stack", err, index)
+	}
+
 	return nil
 }
 
@@ -57,16 +79,7 @@ func (s *Stack) createStackInput() (cloudformation.CreateStackInput, error) {
 		capabilities = append(capabilities, &s.Capabilities[i])
 	}
 
-	var parameters []*cloudformation.Parameter
-	for k, v := range s.Parameters {
-		key := k
-		value := v
-		parameter := cloudformation.Parameter{
-			ParameterKey:   &key,
-			ParameterValue: &value,
-		}
-		parameters = append(parameters, &parameter)
-	}
+	parameters := s.cfnParameters(false)
 
 	var tags []*cloudformation.Tag
 	for k, v := range s.Tags {
@@ -86,6 +99,11 @@ func (s *Stack) createStackInput() (cloudformation.CreateStackInput, error) {
 		Tags:         tags,
 	}
 
+	// CloudFormation rolls back the stack creation once the timeout is reached
+	if s.TimeoutInMinutes > 0 {
+		input.TimeoutInMinutes = aws.Int64(s.TimeoutInMinutes)
+	}
+
 	if s.TemplateURL != "" {
 		input.TemplateURL = &s.TemplateURL
 	} else {
@@ -106,16 +124,7 @@ func (s *Stack) updateStackInput() (cloudformation.UpdateStackInput, error) {
 		capabilities = append(capabilities, &s.Capabilities[i])
 	}
 
-	var parameters []*cloudformation.Parameter
-	for k, v := range s.Parameters {
-		key := k
-		value := v
-		parameter := cloudformation.Parameter{
-			ParameterKey:   &key,
-			ParameterValue: &value,
-		}
-		parameters = append(parameters, &parameter)
-	}
+	parameters := s.cfnParameters(true)
 
 	var tags []*cloudformation.Tag
 	for k, v := range s.Tags {
@@ -155,22 +164,13 @@ func (s *Stack) deleteStackInput() (cloudformation.DeleteStackInput, error) {
 }
 
 ///TODO Make Create Changeset Input Method DRY
-func (s *Stack) createChangeSetInput(ctx context.Context) (cloudformation.CreateChangeSetInput, error) {
+func (s *Stack) createChangeSetInput(ctx context.Context, changeSetType string) (cloudformation.CreateChangeSetInput, error) {
 	var capabilities []*string
 	for i := range s.Capabilities {
 		capabilities = append(capabilities, &s.Capabilities[i])
 	}
 
-	var parameters []*cloudformation.Parameter
-	for k, v := range s.Parameters {
-		key := k
-		value := v
-		parameter := cloudformation.Parameter{
-			ParameterKey:   &key,
-			ParameterValue: &value,
-		}
-		parameters = append(parameters, &parameter)
-	}
+	parameters := s.cfnParameters(changeSetType != "CREATE")
 
 	var tags []*cloudformation.Tag
 	for k, v := range s.Tags {
@@ -195,6 +195,7 @@ func (s *Stack) createChangeSetInput(ctx context.Context) (cloudformation.Create
 		ChangeSetName:       &changeSetName,
 		Tags:                tags,
 		IncludeNestedStacks: &includeNestedStacks,
+		ChangeSetType:       aws.String(changeSetType),
 	}
 
 	if s.TemplateURL != "" {
@@ -213,7 +214,7 @@ func (s *Stack) createChangeSetInput(ctx context.Context) (cloudformation.Create
 }
 
 func (s *Stack) status(ctx context.Context, cm CFNManager) (string, error) {
-	res, err := cm.DescribeStacks(s.StackName)
+	res, err := cm.DescribeStacks(ctx, s.StackName)
 	if err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
@@ -221,141 +222,319 @@ func (s *Stack) status(ctx context.Context, cm CFNManager) (string, error) {
 				return "DOESN'T EXIST", nil
 			default:
 				// logger.ColorPrintf(ctx,"ERROR CODE: %s", aerr.Code())
-				return "", errors.New(fmt.Sprintf("Failed while checking stack status, ERROR %+v", err.Error()))
+				return "", fmt.Errorf("Failed while checking stack status, ERROR %w", err)
 			}
 		}
+		return "", err
 	}
 
 	cfnStack := res.Stacks[0]
 	return *cfnStack.StackStatus, nil
 }
 
-func (s *Stack) ApplyChanges(ctx context.Context, cm CFNManager) error {
+// Status returns the current status of the stack, "DOESN'T EXIST" when it was never created
+func (s *Stack) Status(ctx context.Context, cm CFNManager) (string, error) {
+	return s.status(ctx, cm)
+}
+
+/*
+CancelUpdate cancels the update of the stack if one is in progress, CloudFormation then rolls the update back.
+It reports whether the update was canceled. The rollback isn't waited for.
+*/
+func (s *Stack) CancelUpdate(ctx context.Context, cm CFNManager) (bool, error) {
+	status, err := s.status(ctx, cm)
+	if err != nil || status != "UPDATE_IN_PROGRESS" {
+		return false, err
+	}
+
+	logger.Log.WarnCtxf(ctx, "Canceling Update... as the stack is in %s state.\n", status)
+	if _, err := cm.CancelUpdateStack(ctx, s.StackName); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+/*
+ApplyChanges creates the stack or updates it depending on its status and returns the action taken:
+PlanCreate, PlanUpdate or PlanSkip when the update has no change.
+*/
+func (s *Stack) ApplyChanges(ctx context.Context, cm CFNManager) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	status, err := s.status(ctx, cm)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	status, err = s.recover(ctx, cm, status, true)
+	if err != nil {
+		return "", err
 	}
 
+	return s.applyChanges(ctx, cm, status)
+}
+
+// applyChanges creates or updates the stack of the given status, the status is looked up and recovered by the caller
+func (s *Stack) applyChanges(ctx context.Context, cm CFNManager, status string) (string, error) {
 	switch status {
 	case "DELETE_COMPLETE", "DOESN'T EXIST":
 		logger.Log.InfoCtxf(ctx, "Creating Stack... as the stack is in %s state.\n", status)
 		i, err := s.createStackInput()
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		_, err = cm.CreateStackWithWait(ctx, &i)
 		if err != nil {
-			return err
+			return "", err
 		}
+		return PlanCreate, nil
+
+	// a change set kept by a dry run created the stack without resources, it can only be created through a change set
+	case "REVIEW_IN_PROGRESS":
+		logger.Log.InfoCtxf(ctx, "Creating Stack... through a change set as the stack is in %s state.\n", status)
+		i, err := s.createChangeSetInput(ctx, "CREATE")
+		if err != nil {
+			return "", err
+		}
+
+		cs, err := cm.CreateChangeSetWithWait(ctx, &i)
+		if err != nil {
+			return "", err
+		}
+		_, err = cm.ExecuteChangeSetWithWait(ctx, &cloudformation.ExecuteChangeSetInput{
+			ChangeSetName: cs.Id,
+			StackName:     aws.String(s.StackName),
+		})
+		if err != nil {
+			return "", err
+		}
+		return PlanCreate, nil
+
 	case "UPDATE_FAILED", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_COMPLETE", "CREATE_COMPLETE":
 		logger.Log.InfoCtxf(ctx, "Updating Stack... as the stack is in %s state.\n", status)
 		i, err := s.updateStackInput()
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		_, err = cm.UpdateStackWithWait(ctx, &i)
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok {
-				switch aerr.Code() {
-				case "ValidationError":
-					logger.Log.WarnCtxf(ctx, "Skipping... Update. Warning: %s\n", err.Error())
-				default:
-					return err
-				}
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ValidationError" && isNoChange(aerr.Message()) {
+				logger.Log.WarnCtxf(ctx, "Skipping... Update. Warning: %s\n", err.Error())
+				return PlanSkip, nil
 			}
+			return "", err
 		}
+		return PlanUpdate, nil
 
 	default:
-		return errors.New(fmt.Sprintf("Stopping... the launch as the stack: %s status is: %s\n", s.StackName, status))
+		return "", errors.New(fmt.Sprintf("Stopping... the launch as the stack: %s status is: %s\n", s.StackName, status))
 	}
-
-	return nil
 }
 
-func (s *Stack) Destroy(ctx context.Context, cm CFNManager) error {
+// Destroy deletes the stack and returns the action taken: PlanDelete or PlanSkip when the stack doesn't exist
+func (s *Stack) Destroy(ctx context.Context, cm CFNManager) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	status, err := s.status(ctx, cm)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	status, err = s.recover(ctx, cm, status, false)
+	if err != nil {
+		return "", err
 	}
 
 	switch status {
 	case "DELETE_COMPLETE", "DOESN'T EXIST":
 		logger.Log.InfoCtxf(ctx, "Skipping delete... as the stack is in %s state.\n", status)
-		return nil
+		return PlanSkip, nil
 
-	case "CREATE_COMPLETE", "UPDATE_COMPLETE", "ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_FAILED", "ROLLBACK_FAILED", "DELETE_FAILED":
+	case "CREATE_COMPLETE", "UPDATE_COMPLETE", "ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_FAILED", "ROLLBACK_FAILED", "DELETE_FAILED", "REVIEW_IN_PROGRESS":
 		logger.Log.InfoCtxf(ctx, "Deleting Stack... as the stack is in %s state.\n", status)
 		i, err := s.deleteStackInput()
 		if err != nil {
-			return err
+			return "", err
 		}
 		_, err = cm.DeleteStackWithWait(ctx, &i)
 		if err != nil {
-			return err
+			return "", err
 		}
+		return PlanDelete, nil
 
 	default:
-		return errors.New(fmt.Sprintf("Stopping... the deletion as the stack: %s status is: %s\n", s.StackName, status))
+		return "", errors.New(fmt.Sprintf("Stopping... the deletion as the stack: %s status is: %s\n", s.StackName, status))
 	}
+}
 
-	return nil
+// ApplyDryRun returns the plan of ApplyChanges, the resource changes of an update are read from a change set
+func (s *Stack) ApplyDryRun(ctx context.Context, cm CFNManager) (StackPlan, error) {
+	return s.PlanChanges(ctx, cm, false)
 }
 
-func (s *Stack) ApplyDryRun(ctx context.Context, cm CFNManager) error {
+/*
+PlanChanges returns the plan of ApplyChanges. The change sets are deleted once described unless keepChangeSet is set,
+in which case new stacks are planned with a CREATE change set too so that the plan can be applied later with ApplyPlan.
+*/
+func (s *Stack) PlanChanges(ctx context.Context, cm CFNManager, keepChangeSet bool) (StackPlan, error) {
+	plan := StackPlan{StackName: s.StackName, Action: PlanSkip}
 	status, err := s.status(ctx, cm)
 	if err != nil {
-		return err
+		return plan, err
+	}
+	plan.Status = status
+
+	switch {
+	case status == "ROLLBACK_COMPLETE" && s.Recovery.RollbackComplete == RecoveryRecreate && !keepChangeSet:
+		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Will be deleted and created again.\n", status)
+		plan.Action = PlanCreate
+		plan.Reason = "the stack is deleted first as per its recovery policy"
+		return plan, nil
+	case status == "UPDATE_ROLLBACK_FAILED" && s.Recovery.UpdateRollbackFailed == RecoveryContinue:
+		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Update rollback will be continued.\n", status)
+		plan.Action = PlanBlocked
+		plan.Reason = "the update rollback is continued first as per its recovery policy, the changes can't be planned until then"
+		return plan, nil
+	case inProgress(status) && s.Recovery.InProgress != RecoveryFail:
+		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. %s\n", status, cm.describeOperation(ctx, s.StackName))
+		plan.Action = PlanBlocked
+		plan.Reason = "another operation is in progress, the deployment waits for it but the changes can't be planned until then"
+		return plan, nil
 	}
 
 	switch status {
-	case "DELETE_COMPLETE", "DOESN'T EXIST":
+	case "DELETE_COMPLETE", "DOESN'T EXIST", "REVIEW_IN_PROGRESS":
+		plan.Action = PlanCreate
+		if keepChangeSet {
+			if _, err := s.planChangeSet(ctx, cm, &plan, "CREATE", true); err != nil {
+				return plan, err
+			}
+		}
 		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Will be created.\n", status)
 
 	case "UPDATE_FAILED", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_COMPLETE", "CREATE_COMPLETE":
-		// logger.ColorPrintf(ctx,"[DEBUG] Creating Changeset... for the stack: %s is at %s state\n", status, s.StackName)
-		i, err := s.createChangeSetInput(ctx)
+		changed, err := s.planChangeSet(ctx, cm, &plan, "UPDATE", keepChangeSet)
 		if err != nil {
-			return err
+			return plan, err
+		}
+		if !changed {
+			logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. No change detected.\n", status)
+			return plan, nil
 		}
+		plan.Action = PlanUpdate
+		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Will be updated with %d resource change(s).\n", status, len(plan.Changes))
 
-		cs, err := cm.CreateChangeSetWithWait(ctx, &i)
-		if err != nil {
-			if strings.Contains(err.Error(), "ResourceNotReady:") {
-				logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. No change detected.\n", status)
-				return nil
-			}
-			return err
+	default:
+		logger.Log.InfoCtxf(ctx, "Can't run the operations as Stack is in %s state.\n", status)
+		plan.Action = PlanBlocked
+		plan.Reason = fmt.Sprintf("stack is in %s state", status)
+	}
+
+	return plan, nil
+}
+
+// noChangeReasons are the reasons of the updates and change sets that failed as the stack is already up to date
+var noChangeReasons = []string{"didn't contain changes", "No updates are to be performed"}
+
+// isNoChange reports whether the reason of a failed update or change set is the stack being already up to date
+func isNoChange(reason string) bool {
+	for _, noChange := range noChangeReasons {
+		if strings.Contains(reason, noChange) {
+			return true
 		}
+	}
+	return false
+}
 
-		link := fmt.Sprintf("https://us-east-1.console.aws.amazon.com/cloudformation/home?region=%s#/stacks/changesets/changes?stackId=%s&changeSetId=%s", "us-east-1", url.QueryEscape(*cs.StackId), url.QueryEscape(*cs.Id))
+// planChangeSet creates a change set and adds its resource changes to the plan, it reports false when the change set has no changes
+func (s *Stack) planChangeSet(ctx context.Context, cm CFNManager, plan *StackPlan, changeSetType string, keep bool) (bool, error) {
+	i, err := s.createChangeSetInput(ctx, changeSetType)
+	if err != nil {
+		return false, err
+	}
 
-		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Will be updated.\n\tChangeSet Link: %s\n", status, link)
+	cs, err := cm.CreateChangeSetWithWait(ctx, &i)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, err
+		}
+		return false, s.changeSetFailure(ctx, cm, *i.ChangeSetName, err)
+	}
 
-	default:
-		logger.Log.InfoCtxf(ctx, "Can't run the operations as Stack is in %s state.\n", status)
+	changes, err := cm.ResourceChanges(ctx, s.StackName, *i.ChangeSetName)
+	if err != nil {
+		return false, err
 	}
+	plan.Changes = changes
 
-	return nil
+	if !keep {
+		s.deleteChangeSet(ctx, cm, *i.ChangeSetName)
+		return true, nil
+	}
+
+	res, err := cm.DescribeStacks(ctx, s.StackName)
+	if err != nil {
+		return false, err
+	}
+	plan.StackID = aws.StringValue(res.Stacks[0].StackId)
+	plan.LastUpdatedTime = res.Stacks[0].LastUpdatedTime
+	plan.ChangeSetID = aws.StringValue(cs.Id)
+	plan.ChangeSetName = aws.StringValue(i.ChangeSetName)
+	logger.Log.InfoCtxf(ctx, "ChangeSet Link: %s\n", cm.changeSetLink(plan.StackID, plan.ChangeSetID))
+	return true, nil
+}
+
+/*
+changeSetFailure tells a change set without changes, which is deleted and reported as nil, from a failed one, e.g. a transform,
+template, parameter or IAM error, which is reported with its status reason. The error of the wait is returned when the
+change set can't be described, e.g. it was never created.
+*/
+func (s *Stack) changeSetFailure(ctx context.Context, cm CFNManager, changeSetName string, err error) error {
+	res, derr := cm.DescribeChangeSet(ctx, s.StackName, changeSetName)
+	if derr != nil || aws.StringValue(res.Status) != "FAILED" {
+		return err
+	}
+
+	reason := aws.StringValue(res.StatusReason)
+	if isNoChange(reason) {
+		s.deleteChangeSet(ctx, cm, changeSetName)
+		return nil
+	}
+	return fmt.Errorf("Stopping... the change set: %s of the stack: %s failed, REASON: %s", changeSetName, s.StackName, reason)
+}
+
+// deleteChangeSet deletes a change set that is no longer needed, failures are only logged
+func (s *Stack) deleteChangeSet(ctx context.Context, cm CFNManager, changeSetName string) {
+	if _, err := cm.DeleteChangeSet(ctx, s.StackName, changeSetName); err != nil {
+		logger.Log.WarnCtxf(ctx, "Failed while deleting the change set: %s. Warning: %s\n", changeSetName, err)
+	}
 }
 
-func (s *Stack) DestroyDryRun(ctx context.Context, cm CFNManager) error {
+// DestroyDryRun returns the plan of Destroy
+func (s *Stack) DestroyDryRun(ctx context.Context, cm CFNManager) (StackPlan, error) {
+	plan := StackPlan{StackName: s.StackName, Action: PlanSkip}
 	status, err := s.status(ctx, cm)
 	if err != nil {
-		return err
+		return plan, err
 	}
+	plan.Status = status
 
 	switch status {
 	case "DELETE_COMPLETE", "DOESN'T EXIST":
 		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Delete will be Skipped.\n", status)
 
-	case "CREATE_COMPLETE", "UPDATE_COMPLETE", "ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_FAILED", "ROLLBACK_FAILED", "DELETE_FAILED":
-		// logger.ColorPrintf(ctx,"[DEBUG] Creating Changeset... for the stack: %s is at %s state\n", status, s.StackName)
+	case "CREATE_COMPLETE", "UPDATE_COMPLETE", "ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_COMPLETE", "UPDATE_ROLLBACK_FAILED", "ROLLBACK_FAILED", "DELETE_FAILED", "REVIEW_IN_PROGRESS":
 		logger.Log.InfoCtxf(ctx, "Stack Status: '%s'. Stack will be deleted.\n", status)
+		plan.Action = PlanDelete
 	default:
 		logger.Log.InfoCtxf(ctx, "Can't run the operations as Stack is in %s state.\n", status)
+		plan.Action = PlanBlocked
+		plan.Reason = fmt.Sprintf("stack is in %s state", status)
 	}
 
-	return nil
+	return plan, nil
 }
@@ -0,0 +1,361 @@
+package cfn
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+func init() {
+	logger.StartWithLabel("ERROR")
+}
+
+func writeTemplate(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "template.yml")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatalf("Failed to write template: %s", err)
+	}
+	return path
+}
+
+func TestApplyChanges(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the stack doesn't exist")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), Parameters: map[string]string{"Env": "dev"}}
+
+		action, err := s.ApplyChanges(ctx, cm)
+		if err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+		if action != PlanCreate {
+			t.Fatalf("Expected the action to be %s but got %s", PlanCreate, action)
+		}
+
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+
+		_, params := fake.StackInput("s1")
+		if params["Env"] != "dev" {
+			t.Fatalf("Expected parameter Env to be dev but got %s", params["Env"])
+		}
+	}
+
+	t.Log("When the stack exists and the template changed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		s.TemplateFile = writeTemplate(t, "Resources: {Queue: {Type: AWS::SQS::Queue}}")
+		action, err := s.ApplyChanges(ctx, cm)
+		if err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+		if action != PlanUpdate {
+			t.Fatalf("Expected the action to be %s but got %s", PlanUpdate, action)
+		}
+
+		if status := fake.StackStatus("s1"); status != "UPDATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be UPDATE_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the stack exists and nothing changed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		action, err := s.ApplyChanges(ctx, cm)
+		if err != nil {
+			t.Fatalf("ApplyChanges should skip the update but found error: %s", err)
+		}
+		if action != PlanSkip {
+			t.Fatalf("Expected the action to be %s but got %s", PlanSkip, action)
+		}
+
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the stack update is rejected")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("s1", "CREATE_COMPLETE")
+		fake.RejectUpdate("s1", "Template format error: Unresolved resource dependencies [Queue] in the Resources block of the template")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		action, err := s.ApplyChanges(ctx, cm)
+		if err == nil || !strings.Contains(err.Error(), "Template format error") {
+			t.Fatalf("Expected the validation error of the update but got %s, %v", action, err)
+		}
+		if action == PlanSkip {
+			t.Fatal("Expected the rejected update not to be skipped")
+		}
+	}
+
+	t.Log("When the stack create fails")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		fake.Fail("s1", "Queue", "Resource limit exceeded")
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.ApplyChanges(ctx, cm); err == nil {
+			t.Fatal("ApplyChanges should return error but found nil")
+		}
+
+		if status := fake.StackStatus("s1"); status != "ROLLBACK_COMPLETE" {
+			t.Fatalf("Expected stack status to be ROLLBACK_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the stack is in a non updatable state")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		fake.PutStack("s1", "ROLLBACK_COMPLETE")
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		if _, err := s.ApplyChanges(ctx, cm); err == nil {
+			t.Fatal("ApplyChanges should return error but found nil")
+		}
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the stack exists")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		fake.PutStack("s1", "UPDATE_COMPLETE")
+		s := Stack{StackName: "s1"}
+
+		action, err := s.Destroy(ctx, cm)
+		if err != nil {
+			t.Fatalf("Destroy should return nil but found error: %s", err)
+		}
+		if action != PlanDelete {
+			t.Fatalf("Expected the action to be %s but got %s", PlanDelete, action)
+		}
+
+		if status := fake.StackStatus("s1"); status != "DOESN'T EXIST" {
+			t.Fatalf("Expected stack to be deleted but status is %s", status)
+		}
+	}
+
+	t.Log("When the stack doesn't exist")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1"}
+
+		action, err := s.Destroy(ctx, cm)
+		if err != nil {
+			t.Fatalf("Destroy should return nil but found error: %s", err)
+		}
+		if action != PlanSkip {
+			t.Fatalf("Expected the action to be %s but got %s", PlanSkip, action)
+		}
+
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no mutating calls but got %v", fake.Calls())
+		}
+	}
+
+	t.Log("When the stack delete fails")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		fake.PutStack("s1", "CREATE_COMPLETE")
+		fake.Fail("s1", "Bucket", "The bucket you tried to delete is not empty")
+		s := Stack{StackName: "s1"}
+
+		if _, err := s.Destroy(ctx, cm); err == nil {
+			t.Fatal("Destroy should return error but found nil")
+		}
+
+		if status := fake.StackStatus("s1"); status != "DELETE_FAILED" {
+			t.Fatalf("Expected stack status to be DELETE_FAILED but got %s", status)
+		}
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the stack doesn't exist")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		plan, err := s.ApplyDryRun(ctx, cm)
+		if err != nil {
+			t.Fatalf("ApplyDryRun should return nil but found error: %s", err)
+		}
+		if plan.Action != PlanCreate {
+			t.Fatalf("Expected plan action to be create but got %s", plan.Action)
+		}
+
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no mutating calls but got %v", fake.Calls())
+		}
+	}
+
+	t.Log("When the stack has changes")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		fake.SetChanges("s1", []*cloudformation.Change{{
+			Type: aws.String("Resource"),
+			ResourceChange: &cloudformation.ResourceChange{
+				Action:             aws.String("Modify"),
+				LogicalResourceId:  aws.String("Queue"),
+				PhysicalResourceId: aws.String("https://sqs.us-east-1.amazonaws.com/123456789012/queue"),
+				ResourceType:       aws.String("AWS::SQS::Queue"),
+				Replacement:        aws.String("True"),
+				Scope:              aws.StringSlice([]string{"Properties"}),
+			},
+		}})
+		s.Parameters = map[string]string{"Env": "prod"}
+		plan, err := s.ApplyDryRun(ctx, cm)
+		if err != nil {
+			t.Fatalf("ApplyDryRun should return nil but found error: %s", err)
+		}
+
+		if plan.Action != PlanUpdate || len(plan.Changes) != 1 {
+			t.Fatalf("Expected an update plan with one change but got %+v", plan)
+		}
+		if calls := fake.Calls(); plan.ChangeSetID != "" || calls[len(calls)-1] != "DeleteChangeSet:s1" {
+			t.Fatalf("Expected the change set to be deleted but got plan %+v and calls %v", plan, calls)
+		}
+		if c := plan.Changes[0]; c.LogicalResourceID != "Queue" || c.Action != "Modify" || c.Replacement != "True" || c.Scope[0] != "Properties" {
+			t.Fatalf("Expected the Queue replacement but got %+v", c)
+		}
+		if plan.Replacements() != 1 {
+			t.Fatalf("Expected 1 replacement but got %d", plan.Replacements())
+		}
+
+		if status := fake.StackStatus("s1"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected dry run to keep the stack status CREATE_COMPLETE but got %s", status)
+		}
+	}
+
+	t.Log("When the stack has no changes")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+		if _, err := s.ApplyChanges(ctx, cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		plan, err := s.ApplyDryRun(ctx, cm)
+		if err != nil {
+			t.Fatalf("ApplyDryRun should return nil but found error: %s", err)
+		}
+		if plan.Action != PlanSkip {
+			t.Fatalf("Expected plan action to be skip but got %s", plan.Action)
+		}
+	}
+}
+
+func TestResolveOutputs(t *testing.T) {
+	ctx := context.Background()
+
+	t.Log("When the parameters don't reference outputs")
+	{
+		cm := CFNManager{Client: cfntest.NewFakeCloudFormation()}
+		s := Stack{StackName: "app", Parameters: map[string]string{"Env": "dev"}}
+
+		rs, err := s.ResolveOutputs(ctx, cm)
+		if err != nil {
+			t.Fatalf("ResolveOutputs should return nil but found error: %s", err)
+		}
+		if rs.Parameters["Env"] != "dev" {
+			t.Fatalf("Expected parameter Env to be dev but got %s", rs.Parameters["Env"])
+		}
+	}
+
+	t.Log("When the referenced outputs exist")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("network", "CREATE_COMPLETE")
+		fake.SetOutputs("network", map[string]string{"VpcId": "vpc-123", "SubnetId": "subnet-456"})
+		cm := CFNManager{Client: fake}
+
+		s := Stack{StackName: "app", Parameters: map[string]string{
+			"VpcId":   `{{ output "network" "VpcId" }}`,
+			"Subnets": `{{output "network" "SubnetId"}},subnet-789`,
+		}}
+
+		refs := s.OutputRefs()
+		if len(refs) != 2 {
+			t.Fatalf("Expected 2 output references but got %v", refs)
+		}
+
+		rs, err := s.ResolveOutputs(ctx, cm)
+		if err != nil {
+			t.Fatalf("ResolveOutputs should return nil but found error: %s", err)
+		}
+		if rs.Parameters["VpcId"] != "vpc-123" {
+			t.Fatalf("Expected parameter VpcId to be vpc-123 but got %s", rs.Parameters["VpcId"])
+		}
+		if rs.Parameters["Subnets"] != "subnet-456,subnet-789" {
+			t.Fatalf("Expected parameter Subnets to be subnet-456,subnet-789 but got %s", rs.Parameters["Subnets"])
+		}
+		if s.Parameters["VpcId"] != `{{ output "network" "VpcId" }}` {
+			t.Fatalf("Expected the original stack parameters to be unchanged but got %s", s.Parameters["VpcId"])
+		}
+	}
+
+	t.Log("When the referenced output doesn't exist")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("network", "CREATE_COMPLETE")
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "app", Parameters: map[string]string{"VpcId": `{{ output "network" "VpcId" }}`}}
+
+		if _, err := s.ResolveOutputs(ctx, cm); err == nil {
+			t.Fatal("ResolveOutputs should return error but found nil")
+		}
+	}
+
+	t.Log("When the referenced stack doesn't exist")
+	{
+		cm := CFNManager{Client: cfntest.NewFakeCloudFormation()}
+		s := Stack{StackName: "app", Parameters: map[string]string{"VpcId": `{{ output "network" "VpcId" }}`}}
+
+		if _, err := s.ResolveOutputs(ctx, cm); err == nil {
+			t.Fatal("ResolveOutputs should return error but found nil")
+		}
+	}
+}
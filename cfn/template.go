@@ -0,0 +1,170 @@
+package cfn
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+/*
+Template is a local CloudFormation template, written in YAML with the short form tags, e.g. !Ref, or in JSON.
+Its elements keep their line in the file so that the problems found in the template can be located.
+*/
+type Template struct {
+	File string
+	Size int
+	// Parameters are listed in the order of the template
+	Parameters []TemplateParameter
+	// ParametersLine is the line of the Parameters section, 0 when the template has none
+	ParametersLine int
+	root           *yaml.Node
+}
+
+// TemplateParameter is a parameter declared by a template along with its constraints
+type TemplateParameter struct {
+	Name           string   `yaml:"-"`
+	Line           int      `yaml:"-"`
+	Type           string   `yaml:"Type"`
+	Default        *string  `yaml:"Default"`
+	AllowedValues  []string `yaml:"AllowedValues"`
+	AllowedPattern string   `yaml:"AllowedPattern"`
+	MinLength      *string  `yaml:"MinLength"`
+	MaxLength      *string  `yaml:"MaxLength"`
+	MinValue       *string  `yaml:"MinValue"`
+	MaxValue       *string  `yaml:"MaxValue"`
+}
+
+// TemplateProblem is a problem found in a template, Line is 0 when it isn't about a line of the file
+type TemplateProblem struct {
+	File      string
+	Line      int
+	StackName string
+	Message   string
+	// Warning is a problem that CloudFormation accepts, e.g. an unused parameter
+	Warning bool
+}
+
+func (p TemplateProblem) String() string {
+	location := p.File
+	if p.Line > 0 {
+		location = fmt.Sprintf("%s:%d", p.File, p.Line)
+	}
+	message := p.Message
+	if p.Warning {
+		message = "warning: " + message
+	}
+	if p.StackName == "" {
+		return fmt.Sprintf("%s: %s", location, message)
+	}
+	return fmt.Sprintf("%s: [STACK: %s] %s", location, p.StackName, message)
+}
+
+func (p TemplateProblem) Error() string {
+	return p.String()
+}
+
+// TemplateError reports all the problems found in the templates at once
+type TemplateError struct {
+	Problems []TemplateProblem
+}
+
+// HasErrors reports whether some problems aren't warnings
+func (e *TemplateError) HasErrors() bool {
+	for _, p := range e.Problems {
+		if !p.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *TemplateError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d problem(s) found in the templates:", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  " + p.String())
+	}
+	return b.String()
+}
+
+/*
+ParseTemplate reads and parses a local template. The tabs indenting the JSON templates are replaced by spaces,
+YAML not allowing them. A template that can't be parsed is returned as a TemplateProblem located at the failing line.
+*/
+func ParseTemplate(path string) (*Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Template{File: path, Size: len(data)}
+	body := data
+	// a tab can only be a whitespace in a valid JSON document, the strings escape it
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		body = bytes.ReplaceAll(data, []byte("\t"), []byte(" "))
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		return nil, parseProblem(path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, TemplateProblem{File: path, Line: 1, Message: "the template should be a map of its sections"}
+	}
+	t.root = doc.Content[0]
+
+	key, params := mappingEntry(t.root, "Parameters")
+	if params == nil {
+		return t, nil
+	}
+	t.ParametersLine = key.Line
+	if params.Kind != yaml.MappingNode {
+		return nil, TemplateProblem{File: path, Line: key.Line, Message: "the Parameters section should be a map of the parameters"}
+	}
+	for i := 0; i+1 < len(params.Content); i += 2 {
+		name, value := params.Content[i], params.Content[i+1]
+		p := TemplateParameter{Name: name.Value, Line: name.Line}
+		if err := value.Decode(&p); err != nil {
+			return nil, parseProblem(path, fmt.Errorf("parameter %s: %w", name.Value, err))
+		}
+		t.Parameters = append(t.Parameters, p)
+	}
+	return t, nil
+}
+
+// parseProblem locates the parse error of the template from the line given in its message
+func parseProblem(path string, err error) TemplateProblem {
+	line := 0
+	if m := yamlLinePattern.FindStringSubmatch(err.Error()); m != nil {
+		line, _ = strconv.Atoi(m[1])
+	}
+	message := strings.Join(strings.Fields(strings.TrimPrefix(err.Error(), "yaml: ")), " ")
+	return TemplateProblem{File: path, Line: line, Message: "can't parse the template: " + message}
+}
+
+// Parameter returns the parameter of the template with the given name
+func (t *Template) Parameter(name string) (TemplateParameter, bool) {
+	for _, p := range t.Parameters {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return TemplateParameter{}, false
+}
+
+// mappingEntry returns the key and value nodes of the mapping for the given key, nil when the key is missing
+func mappingEntry(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i], m.Content[i+1]
+		}
+	}
+	return nil, nil
+}
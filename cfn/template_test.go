@@ -0,0 +1,66 @@
+package cfn
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTemplate(t *testing.T) {
+	t.Log("When the template is written in YAML with short form tags")
+	{
+		path := writeTemplate(t, `Parameters:
+  Env:
+    Type: String
+    AllowedValues: [dev, prod]
+  Size:
+    Type: Number
+    Default: 2
+    MinValue: 1
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: !Sub '${Env}-bucket'
+      Tags:
+        - Key: Size
+          Value: !Ref Size
+`)
+		tpl, err := ParseTemplate(path)
+		if err != nil {
+			t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+		}
+		if len(tpl.Parameters) != 2 || tpl.Parameters[0].Name != "Env" || tpl.Parameters[0].Line != 2 || tpl.ParametersLine != 1 {
+			t.Fatalf("Expected the parameters Env and Size with their lines but got %+v", tpl.Parameters)
+		}
+		if size, _ := tpl.Parameter("Size"); size.Default == nil || *size.Default != "2" || *size.MinValue != "1" {
+			t.Fatalf("Expected the default and min value of Size but got %+v", size)
+		}
+	}
+
+	t.Log("When the template is written in JSON indented with tabs")
+	{
+		path := filepath.Join(t.TempDir(), "template.json")
+		if err := os.WriteFile(path, []byte("{\n\t\"Parameters\": {\n\t\t\"Env\": {\"Type\": \"String\", \"Default\": \"dev\"}\n\t},\n\t\"Resources\": {}\n}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		tpl, err := ParseTemplate(path)
+		if err != nil {
+			t.Fatalf("ParseTemplate should return nil but found error: %s", err)
+		}
+		if env, ok := tpl.Parameter("Env"); !ok || env.Line != 3 || *env.Default != "dev" {
+			t.Fatalf("Expected the parameter Env on line 3 but got %+v", tpl.Parameters)
+		}
+	}
+
+	t.Log("When the template can't be parsed")
+	{
+		path := writeTemplate(t, "Parameters:\n  Env:\n    Type: String\n    Default: dev: prod\nResources: {}\n")
+		_, err := ParseTemplate(path)
+		var problem TemplateProblem
+		if !errors.As(err, &problem) || problem.Line != 4 || problem.File != path {
+			t.Fatalf("Expected the parse error to be located on line 4 but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package cfn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+/*
+TimeoutError is returned when the deadline of the context expires while waiting for a stack operation.
+The stack timeout, the flow timeout and the compose run timeout all end up as context deadlines.
+CloudFormation isn't told about it, the operation may still be in progress.
+*/
+type TimeoutError struct {
+	StackName string
+	// Operation is the waited operation, e.g. UpdateStack
+	Operation string
+	// Status is the stack status when the wait was aborted
+	Status string
+	Err    error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Wait %s timed out, STACK: %s, STATUS: %s. The operation may still be in progress in CloudFormation", e.Operation, e.StackName, e.Status)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// timeoutError returns a TimeoutError when the deadline of the context is exceeded, nil otherwise
+func (cm CFNManager) timeoutError(ctx context.Context, operation, stackName string) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil
+	}
+
+	terr := &TimeoutError{StackName: stackName, Operation: operation, Status: "UNKNOWN", Err: ctx.Err()}
+	if res, err := cm.DescribeStacks(ctx, stackName); err == nil && len(res.Stacks) > 0 {
+		terr.Status = aws.StringValue(res.Stacks[0].StackStatus)
+	}
+	return terr
+}
+
+// waitError reports the failed wait of an operation either as a timeout or as a stack failure
+func (cm CFNManager) waitError(ctx context.Context, operation, stackName, stackID, lastEventID string, err error) error {
+	if terr := cm.timeoutError(ctx, operation, stackName); terr != nil {
+		return terr
+	}
+	return cm.stackError(ctx, operation, stackName, stackID, lastEventID, err)
+}
+
+// withTimeout bounds the operations of the stack by its timeout, if any
+func (s *Stack) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.TimeoutInMinutes <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(s.TimeoutInMinutes)*time.Minute)
+}
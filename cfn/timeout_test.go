@@ -0,0 +1,69 @@
+package cfn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+)
+
+func TestStackTimeout(t *testing.T) {
+	t.Log("When the stack with a timeout is created")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}"), TimeoutInMinutes: 10}
+
+		if _, err := s.ApplyChanges(context.Background(), cm); err != nil {
+			t.Fatalf("ApplyChanges should return nil but found error: %s", err)
+		}
+
+		res, err := cm.DescribeStacks(context.Background(), "s1")
+		if err != nil {
+			t.Fatalf("DescribeStacks should return nil but found error: %s", err)
+		}
+		if timeout := aws.Int64Value(res.Stacks[0].TimeoutInMinutes); timeout != 10 {
+			t.Fatalf("Expected the stack to be created with a 10 minutes timeout but got %d", timeout)
+		}
+	}
+
+	t.Log("When the deadline expires while waiting for the stack")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetLatency("s1", time.Second)
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := s.ApplyChanges(ctx, cm)
+		var terr *TimeoutError
+		if !errors.As(err, &terr) || terr.Operation != "CreateStack" || terr.Status != "CREATE_IN_PROGRESS" {
+			t.Fatalf("Expected the create to time out but got %v", err)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected the error to wrap the context deadline but got %v", err)
+		}
+	}
+
+	t.Log("When the stack is canceled without deadline")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetLatency("s1", time.Second)
+		cm := CFNManager{Client: fake}
+		s := Stack{StackName: "s1", TemplateFile: writeTemplate(t, "Resources: {}")}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := s.ApplyChanges(ctx, cm)
+		var terr *TimeoutError
+		if err == nil || errors.As(err, &terr) {
+			t.Fatalf("Expected the create to fail without timeout but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"github.com/rbalman/cfn-compose/cfn"
+	"github.com/rbalman/cfn-compose/compose"
+	"github.com/spf13/cobra"
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Applies a plan saved by the deploy dry run",
+	Long:  `Applies a plan saved by 'cfnc deploy --dry-run --keep-changesets --plan-out <file>'. Executes exactly the change sets recorded in the plan, following the sequence specified in the compose configuration. Refuses to proceed when a stack changed since the plan was made or when a change set is no longer valid.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// the usage only helps with invalid flags, not with a failed run
+		cmd.SilenceUsage = true
+
+		sinks, closeSinks, err := eventSinks()
+		if err != nil {
+			return err
+		}
+		defer closeSinks()
+
+		c := compose.Composer{
+			LogLevel:        logLevel,
+			DeployMode:      true,
+			ConfigFile:      configFile,
+			Concurrency:     concurrency,
+			Retry:           cfn.RetryPolicy{MaxAttempts: retryMaxAttempts, BaseDelay: retryBaseDelay, MaxDelay: retryMaxDelay},
+			PlanFile:        planFile,
+			Sinks:           sinks,
+			Timeout:         timeout,
+			ContinueOnError: continueOnError,
+			CancelUpdates:   cancelUpdates,
+		}
+
+		ctx, stop := signalContext()
+		defer stop()
+
+		setupOutput(&c)
+		return c.ApplyContext(ctx)
+	},
+}
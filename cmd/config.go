@@ -22,16 +22,28 @@ var validateCmd = &cobra.Command{
 	Use:     "val",
 	Short:   "Validates the compose configuration",
 	Aliases: []string{"vd"},
-	Long:    `Static validation of the compose configuration. helps to debug configuration issues`,
+	Long:    `Static validation of the compose configuration, of the stack parameters and of the local templates, without calling AWS. helps to debug configuration issues`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cc, err := config.GetComposeConfig(configFile)
+		// the usage only helps with invalid flags, not with an invalid compose file
+		cmd.SilenceUsage = true
+
+		cc, err := config.LoadComposeConfig(configFile, config.StateDir(configFile))
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed while fetching compose file: %s\n", err.Error()))
+			return &compose.RunError{Code: compose.ExitConfig, Err: fmt.Errorf("Failed while fetching compose file: %w", err)}
 		}
 
 		err = cc.Validate()
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed while validating compose file: %s\n", err.Error()))
+			return &compose.RunError{Code: compose.ExitConfig, Err: fmt.Errorf("Failed while validating compose file: %w", err)}
+		}
+
+		report := cc.LintTemplates()
+		if report.HasErrors() {
+			return &compose.RunError{Code: compose.ExitConfig, Err: fmt.Errorf("Failed while validating the templates: %w", report)}
+		}
+		// the warnings alone don't fail the validation
+		if len(report.Problems) > 0 {
+			fmt.Println(report)
 		}
 
 		fmt.Printf("All good!!")
@@ -45,14 +57,17 @@ var visualizeCmd = &cobra.Command{
 	Aliases: []string{"vz"},
 	Long:    `Visualize the stacks dependencies and creation order specified in the compose file`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cc, err := config.GetComposeConfig(configFile)
+		// the usage only helps with invalid flags, not with an invalid compose file
+		cmd.SilenceUsage = true
+
+		cc, err := config.LoadComposeConfig(configFile, config.StateDir(configFile))
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed while fetching compose file: %s\n", err.Error()))
+			return &compose.RunError{Code: compose.ExitConfig, Err: fmt.Errorf("Failed while fetching compose file: %w", err)}
 		}
 
 		err = cc.Validate()
 		if err != nil {
-			return errors.New(fmt.Sprintf("Failed while validating compose file: %s\n", err.Error()))
+			return &compose.RunError{Code: compose.ExitConfig, Err: fmt.Errorf("Failed while validating compose file: %w", err)}
 		}
 
 		flowsMap := compose.SortFlows(cc.Flows)
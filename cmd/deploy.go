@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/rbalman/cfn-compose/cfn"
 	"github.com/rbalman/cfn-compose/compose"
 	"github.com/spf13/cobra"
 )
@@ -10,16 +11,38 @@ var deployCmd = &cobra.Command{
 	Short:   "Deploys the stacks based on the sequence specified in the compose configuration",
 	Aliases: []string{"dp"},
 	Long:    `Deploys stacks based on the sequence specified in the compose configuration. Behind the scene it creates the stack if not created and updates the stack if already created. Supports dryRun mode, use --dry-run or -d flag.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// the usage only helps with invalid flags, not with a failed run
+		cmd.SilenceUsage = true
+
+		sinks, closeSinks, err := eventSinks()
+		if err != nil {
+			return err
+		}
+		defer closeSinks()
+
 		c := compose.Composer{
 			LogLevel:         logLevel,
 			CherryPickedFlow: flowName,
 			DeployMode:       true,
 			DryRun:           dryRun,
 			ConfigFile:       configFile,
+			Concurrency:      concurrency,
+			Retry:            cfn.RetryPolicy{MaxAttempts: retryMaxAttempts, BaseDelay: retryBaseDelay, MaxDelay: retryMaxDelay},
+			Sinks:            sinks,
+			Timeout:          timeout,
+			ContinueOnError:  continueOnError,
+			CancelUpdates:    cancelUpdates,
+			PlanOut:          planOut,
+			KeepChangeSets:   keepChangeSets,
+			ChangeSetMode:    changeSetMode,
+			AutoApprove:      autoApprove,
 		}
 
-		c.PrintConfig()
-		c.Apply()
+		ctx, stop := signalContext()
+		defer stop()
+
+		setupOutput(&c)
+		return c.ApplyContext(ctx)
 	},
 }
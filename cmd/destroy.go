@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"github.com/rbalman/cfn-compose/cfn"
 	"github.com/rbalman/cfn-compose/compose"
 	"github.com/spf13/cobra"
 )
@@ -10,16 +11,34 @@ var destroyCmd = &cobra.Command{
 	Short:   "Destroys all the stacks in the reverse order of creation",
 	Aliases: []string{"ds"},
 	Long:    `Destroys all the stacks in the reverse order of creation as specified in the compose configuration. Supports dryRun mode, use --dry-run or -d flag.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// the usage only helps with invalid flags, not with a failed run
+		cmd.SilenceUsage = true
+
+		sinks, closeSinks, err := eventSinks()
+		if err != nil {
+			return err
+		}
+		defer closeSinks()
+
 		c := compose.Composer{
 			LogLevel:         logLevel,
 			CherryPickedFlow: flowName,
 			DeployMode:       false,
 			DryRun:           dryRun,
 			ConfigFile:       configFile,
+			Concurrency:      concurrency,
+			Retry:            cfn.RetryPolicy{MaxAttempts: retryMaxAttempts, BaseDelay: retryBaseDelay, MaxDelay: retryMaxDelay},
+			Sinks:            sinks,
+			Timeout:          timeout,
+			ContinueOnError:  continueOnError,
+			PlanOut:          planOut,
 		}
 
-		c.PrintConfig()
-		c.Apply()
+		ctx, stop := signalContext()
+		defer stop()
+
+		setupOutput(&c)
+		return c.ApplyContext(ctx)
 	},
 }
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"github.com/rbalman/cfn-compose/compose"
+)
+
+// eventSinks builds the sinks of the --events-* flags, the returned function closes them once the run is over
+func eventSinks() ([]compose.Sink, func(), error) {
+	var sinks []compose.Sink
+	closeSinks := func() {}
+
+	if eventsFile != "" {
+		sink, err := compose.NewJSONLinesFileSink(eventsFile)
+		if err != nil {
+			return nil, nil, &compose.RunError{Code: compose.ExitConfig, Err: err}
+		}
+		sinks = append(sinks, sink)
+		closeSinks = func() { sink.Close() }
+	}
+	if eventsWebhook != "" {
+		sinks = append(sinks, compose.WebhookSink{URL: eventsWebhook})
+	}
+	if eventsCommand != "" {
+		sinks = append(sinks, compose.CommandSink{Command: eventsCommand})
+	}
+
+	return sinks, closeSinks, nil
+}
@@ -1,15 +1,40 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
+	"time"
 
+	"github.com/rbalman/cfn-compose/compose"
+	"github.com/rbalman/cfn-compose/config"
+	"github.com/rbalman/cfn-compose/libs"
+	"github.com/rbalman/cfn-compose/logger"
 	"github.com/spf13/cobra"
 )
 
 var configFile string
 var logLevel string
+var logFormat string
+var noColor bool
+var logDir string
 var dryRun bool
 var flowName string
+var concurrency int
+var retryMaxAttempts int
+var retryBaseDelay time.Duration
+var retryMaxDelay time.Duration
+var planOut string
+var planFile string
+var keepChangeSets bool
+var changeSetMode bool
+var autoApprove bool
+var timeout time.Duration
+var cancelUpdates bool
+var continueOnError bool
+var eventsFile string
+var eventsWebhook string
+var eventsCommand string
+var ui bool
 
 var rootCmd = &cobra.Command{
 	Use:     "cfnc",
@@ -22,20 +47,70 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "cfn-compose.yml", "File path to compose file")
 	rootCmd.PersistentFlags().StringVarP(&logLevel, "loglevel", "l", "INFO", "Specify Log Levels. Valid Levels are: DEBUG, INFO, WARN, ERROR")
 	rootCmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "d", false, "Run commands in dry run mode")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logger.FormatText, "Log format, text or json with one JSON object per line")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Don't color the logs of the flows, they are only colored on terminals anyway")
+	rootCmd.PersistentFlags().StringVar(&logDir, "log-dir", "", "Also write the logs of every run, flow and stack into a new directory of the given one")
 	deployCmd.PersistentFlags().StringVarP(&flowName, "flow", "f", "", "Cherry pick flow name that you want to deploy")
 	destroyCmd.PersistentFlags().StringVarP(&flowName, "flow", "f", "", "Cherry pick flow name that you want to destroy")
+	deployCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 0, "Maximum number of stacks deployed at the same time, overrides Concurrency from the compose file (default no limit)")
+	destroyCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 0, "Maximum number of stacks destroyed at the same time, overrides Concurrency from the compose file (default no limit)")
+	deployCmd.PersistentFlags().StringVar(&planOut, "plan-out", "", "Write the dry run plan to the given file as JSON, requires --dry-run")
+	destroyCmd.PersistentFlags().StringVar(&planOut, "plan-out", "", "Write the dry run plan to the given file as JSON, requires --dry-run")
+	deployCmd.PersistentFlags().BoolVar(&keepChangeSets, "keep-changesets", false, "Keep the change sets of the dry run so that the plan written with --plan-out can be applied with 'cfnc apply'")
+	deployCmd.PersistentFlags().BoolVar(&changeSetMode, "changeset", false, "Update the stacks through change sets, the resource changes of each change set are confirmed before it is executed")
+	deployCmd.PersistentFlags().BoolVar(&autoApprove, "auto-approve", false, "Execute the change sets of --changeset without confirmation")
+	applyCmd.PersistentFlags().StringVar(&planFile, "plan", "", "Plan file written by 'cfnc deploy --dry-run --keep-changesets --plan-out'")
+	applyCmd.MarkPersistentFlagRequired("plan")
+	deployCmd.PersistentFlags().BoolVar(&cancelUpdates, "cancel-on-interrupt", false, "Cancel the stack updates in progress when the run is interrupted with SIGINT or SIGTERM, CloudFormation then rolls them back")
+	applyCmd.PersistentFlags().BoolVar(&cancelUpdates, "cancel-on-interrupt", false, "Cancel the stack updates in progress when the run is interrupted with SIGINT or SIGTERM, CloudFormation then rolls them back")
+	applyCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 0, "Maximum number of stacks deployed at the same time, overrides Concurrency from the compose file (default no limit)")
+
+	for _, cmd := range []*cobra.Command{deployCmd, destroyCmd, applyCmd} {
+		cmd.PersistentFlags().IntVar(&retryMaxAttempts, "retry-max-attempts", 0, "Maximum attempts of a throttled or failing CloudFormation call, overrides Retry.MaxAttempts from the compose file (default 5)")
+		cmd.PersistentFlags().DurationVar(&retryBaseDelay, "retry-base-delay", 0, "Base delay of the exponential backoff between retries, overrides Retry.BaseDelay from the compose file (default 1s)")
+		cmd.PersistentFlags().DurationVar(&retryMaxDelay, "retry-max-delay", 0, "Maximum delay between retries, overrides Retry.MaxDelay from the compose file (default 30s)")
+		cmd.PersistentFlags().BoolVar(&continueOnError, "continue-on-error", false, "Keep running the stacks not depending on a failed stack instead of stopping the run")
+		cmd.PersistentFlags().StringVar(&eventsFile, "events-file", "", "Append the events of the run to the given file, one JSON object per line")
+		cmd.PersistentFlags().StringVar(&eventsWebhook, "events-webhook", "", "POST every event of the run as JSON to the given URL")
+		cmd.PersistentFlags().StringVar(&eventsCommand, "events-command", "", "Run the given shell command for every event of the run, with the event as JSON on stdin and its type in CFNC_EVENT_TYPE")
+		cmd.PersistentFlags().BoolVar(&ui, "ui", false, "Render a live dashboard of the stacks instead of the logs, only on terminals with the text log format")
+		cmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "Maximum duration of the whole run, e.g. 2h, the stacks still running are reported as timed out (default no limit)")
+	}
 
 	rootCmd.AddCommand(deployCmd)
 	rootCmd.AddCommand(destroyCmd)
+	rootCmd.AddCommand(applyCmd)
 	rootCmd.AddCommand(configCmd)
 	configCmd.AddCommand(validateCmd)
 	configCmd.AddCommand(visualizeCmd)
 	configCmd.AddCommand(generateCmd)
 }
 
+/*
+setupOutput sets the logs of the run and prints its configuration. The flows are colored and the dashboard is rendered
+on terminals only, with JSON logs the tables go to stderr so that stdout only holds JSON lines.
+The rendered compose file is saved into the .cfn-compose directory next to it.
+*/
+func setupOutput(c *compose.Composer) {
+	c.StateDir = config.StateDir(c.ConfigFile)
+	c.LogFormat = logFormat
+	c.LogDir = logDir
+	terminal := libs.IsTerminal(os.Stdout)
+	c.Color = logFormat != logger.FormatJSON && !noColor && os.Getenv("NO_COLOR") == "" && terminal
+	c.UI = ui && logFormat != logger.FormatJSON && terminal
+	if ui && !c.UI {
+		fmt.Fprintln(os.Stderr, "[WARN] The dashboard of --ui needs a terminal and the text log format, logging the run instead.")
+	}
+	if logFormat == logger.FormatJSON {
+		c.Output = os.Stderr
+		return
+	}
+	c.PrintConfig()
+}
+
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(compose.ExitCode(err))
 	}
 }
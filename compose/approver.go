@@ -0,0 +1,101 @@
+package compose
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rbalman/cfn-compose/cfn"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+/*
+promptApprover prints the resource changes of every change set and asks for a confirmation.
+Stacks are deployed concurrently so the prompts are serialized, the other stacks keep deploying meanwhile.
+The answers are read by a single goroutine so that an interrupted prompt doesn't leave a reader behind to take the next answer,
+it is started by the first prompt and stops with the run context.
+*/
+type promptApprover struct {
+	mu      sync.Mutex
+	run     context.Context
+	in      io.Reader
+	out     io.Writer
+	start   sync.Once
+	answers chan string
+}
+
+func newPromptApprover(run context.Context, in io.Reader, out io.Writer) *promptApprover {
+	return &promptApprover{run: run, in: in, out: out, answers: make(chan string)}
+}
+
+/*
+read sends every line of the input to the prompts, the channel is closed once the input or the run is over.
+A read already blocked on the input still waits for the next line once the run is over.
+*/
+func (a *promptApprover) read() {
+	defer close(a.answers)
+	r := bufio.NewReader(a.in)
+	for {
+		answer, err := r.ReadString('\n')
+		if answer != "" {
+			select {
+			case a.answers <- answer:
+			case <-a.run.Done():
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (a *promptApprover) Approve(ctx context.Context, plan cfn.StackPlan) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	flow, _ := ctx.Value(logger.FlowKey).(string)
+	fmt.Fprintf(a.out, "\nFLOW: %s\n", flow)
+	renderStack(a.out, plan)
+	fmt.Fprintf(a.out, "\nExecute the change set of the stack: %s? Only 'yes' will be accepted: ", plan.StackName)
+
+	// the input is only read once a prompt is shown, an interrupted run doesn't wait for the answer
+	a.start.Do(func() { go a.read() })
+	select {
+	case answer, ok := <-a.answers:
+		if !ok {
+			return false, fmt.Errorf("no approval received for the change set of the stack: %s, use --auto-approve in non interactive runs", plan.StackName)
+		}
+		return strings.TrimSpace(answer) == "yes", nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// autoApprover prints the resource changes of every change set before approving it, for the non interactive runs
+type autoApprover struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newAutoApprover(out io.Writer) *autoApprover {
+	return &autoApprover{out: out}
+}
+
+func (a *autoApprover) Approve(ctx context.Context, plan cfn.StackPlan) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	flow, _ := ctx.Value(logger.FlowKey).(string)
+	fmt.Fprintf(a.out, "\nFLOW: %s\n", flow)
+	renderStack(a.out, plan)
+	fmt.Fprintf(a.out, "\nExecuting the change set of the stack: %s as it is auto approved.\n", plan.StackName)
+	return true, nil
+}
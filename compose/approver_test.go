@@ -0,0 +1,101 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+func TestPromptApprover(t *testing.T) {
+	ctx := context.WithValue(context.Background(), logger.FlowKey, "App")
+	plan := cfn.StackPlan{StackName: "api", Status: "UPDATE_COMPLETE", Action: cfn.PlanUpdate, Changes: []cfn.ResourceChange{
+		{Action: "Modify", LogicalResourceID: "Queue", ResourceType: "AWS::SQS::Queue", Replacement: "False"},
+	}}
+
+	t.Log("When the answers are read")
+	{
+		var out bytes.Buffer
+		a := newPromptApprover(ctx, strings.NewReader("yes\nno\n"), &out)
+
+		if approved, err := a.Approve(ctx, plan); err != nil || !approved {
+			t.Fatalf("Expected the first change set to be approved but got %t, %v", approved, err)
+		}
+		if approved, err := a.Approve(ctx, plan); err != nil || approved {
+			t.Fatalf("Expected the second change set to be declined but got %t, %v", approved, err)
+		}
+
+		if !strings.Contains(out.String(), "FLOW: App") || !strings.Contains(out.String(), "~ Modify") {
+			t.Fatalf("Expected the resource changes to be printed but got:\n%s", out.String())
+		}
+	}
+
+	t.Log("When there is no answer to read")
+	{
+		a := newPromptApprover(ctx, strings.NewReader(""), &bytes.Buffer{})
+		if _, err := a.Approve(ctx, plan); err == nil {
+			t.Fatal("Approve should return error but found nil")
+		}
+	}
+
+	t.Log("When the run is interrupted while waiting for the answer")
+	{
+		in, w := io.Pipe()
+		a := newPromptApprover(ctx, in, &bytes.Buffer{})
+
+		cctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if _, err := a.Approve(cctx, plan); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected Approve to be canceled but got %v", err)
+		}
+
+		// the answer typed after the interrupted prompt goes to the next one
+		go w.Write([]byte("yes\n"))
+		if approved, err := a.Approve(ctx, plan); err != nil || !approved {
+			t.Fatalf("Expected the next change set to be approved but got %t, %v", approved, err)
+		}
+	}
+
+	t.Log("When the run is over")
+	{
+		in, w := io.Pipe()
+		run, cancel := context.WithCancel(ctx)
+		a := newPromptApprover(run, in, &bytes.Buffer{})
+
+		go w.Write([]byte("yes\n"))
+		if approved, err := a.Approve(run, plan); err != nil || !approved {
+			t.Fatalf("Expected the change set to be approved but got %t, %v", approved, err)
+		}
+
+		// the answer typed once the run is over has no prompt to go to
+		w.Write([]byte("no\n"))
+		cancel()
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case answer, ok := <-a.answers:
+			if ok {
+				t.Fatalf("Expected the reader to stop with the run but got the answer %q", answer)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the reader to stop with the run")
+		}
+	}
+
+	t.Log("When the change sets are auto approved")
+	{
+		var out bytes.Buffer
+		a := newAutoApprover(&out)
+		if approved, err := a.Approve(ctx, plan); err != nil || !approved {
+			t.Fatalf("Expected the change set to be approved but got %t, %v", approved, err)
+		}
+		if !strings.Contains(out.String(), "FLOW: App") || !strings.Contains(out.String(), "~ Modify") {
+			t.Fatalf("Expected the resource changes to be printed but got:\n%s", out.String())
+		}
+	}
+}
@@ -2,60 +2,103 @@ package compose
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"github.com/rbalman/cfn-compose/cfn"
-	"github.com/rbalman/cfn-compose/config"
 	"github.com/rbalman/cfn-compose/logger"
+	"time"
 )
 
 type CfnTask struct {
-	Flow       config.Flow
+	FlowName   string
+	Order      int
+	Stack      cfn.Stack
 	DryRun     bool
 	DeployMode bool
 	CM         cfn.CFNManager
+	// KeepChangeSet keeps the change sets of the dry run so that its plan can be applied later
+	KeepChangeSet bool
+	// Plan is the saved plan of the stack to apply instead of computing the changes
+	Plan *cfn.StackPlan
+	// ChangeSetMode updates the stacks through change sets, executed once approved by Approve
+	ChangeSetMode bool
+	Approve       cfn.Approver
+	// Deadline is the deadline of the flow, if any
+	Deadline time.Time
+	// Events receives the stack_started event, may be nil
+	Events *EventBus
 }
 
-func (ct CfnTask) Execute(ctx context.Context) Result {
-	name := ct.Flow.Name
-	flow := ct.Flow
-	dryRun := ct.DryRun
-	deployMode := ct.DeployMode
-	ctx = context.WithValue(ctx, "flow", name)
-	ctx = context.WithValue(ctx, "order", flow.Order)
-
-	var stacks []cfn.Stack
-	if deployMode {
-		stacks = flow.Stacks
+func (ct CfnTask) Execute(ctx context.Context) (result Result) {
+	name := ct.FlowName
+	stack := ct.Stack
+	ctx = context.WithValue(ctx, logger.FlowKey, name)
+	ctx = context.WithValue(ctx, logger.OrderKey, ct.Order)
+	ctx = context.WithValue(ctx, logger.StackKey, stack.StackName)
+	if !ct.Deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, ct.Deadline)
+		defer cancel()
+	}
+
+	result = Result{FlowName: name, Order: ct.Order, StackName: stack.StackName}
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	var err error
+	if ctx.Err() != nil {
+		// nothing is started once the flow or the compose run is over
+		err = fmt.Errorf("Skipping... %w: %s", errNotStarted, ctx.Err())
 	} else {
-		stacks = reverseStackOrder(flow.Stacks)
+		ct.Events.Emit(Event{Type: EventStackStarted, FlowName: name, Order: ct.Order, StackName: stack.StackName})
+		// the outputs of a saved plan are already resolved in its change set
+		if ct.DeployMode && ct.Plan == nil {
+			stack, err = stack.ResolveOutputs(ctx, ct.CM)
+			if err != nil && ct.DryRun {
+				logger.Log.WarnCtxf(ctx, "Skipping... dry run as the referenced outputs are not available yet: %s\n", err)
+				result.Plan = &cfn.StackPlan{StackName: stack.StackName, Action: cfn.PlanBlocked, Reason: err.Error()}
+				if result.Plan.Status, err = stack.Status(ctx, ct.CM); err == nil {
+					return result
+				}
+			}
+		}
+	}
+
+	if err == nil {
+		err = ct.run(ctx, stack, &result)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("[FLOW: %s] [STACK: %s]. Error: %w\n", name, stack.StackName, err)
+		logger.FromContext(ctx).Infoln(err.Error())
+		result.Error = err
 	}
 
-	for _, stack := range stacks {
-		ctx := context.WithValue(ctx, "stack", stack.StackName)
+	return result
+}
+
+func (ct CfnTask) run(ctx context.Context, stack cfn.Stack, result *Result) error {
+	if ct.DryRun {
+		var plan cfn.StackPlan
 		var err error
-		if dryRun {
-			if deployMode {
-				err = stack.ApplyDryRun(ctx, ct.CM)
-			} else {
-				err = stack.DestroyDryRun(ctx, ct.CM)
-			}
+		if ct.DeployMode {
+			plan, err = stack.PlanChanges(ctx, ct.CM, ct.KeepChangeSet)
 		} else {
-			if deployMode {
-				err = stack.ApplyChanges(ctx, ct.CM)
-			} else {
-				err = stack.Destroy(ctx, ct.CM)
-			}
+			plan, err = stack.DestroyDryRun(ctx, ct.CM)
 		}
+		result.Plan = &plan
+		return err
+	}
 
-		if err != nil {
-			errStr := fmt.Sprintf("[FLOW: %s] [STACK: %s]. Error: %s\n", name, stack.StackName, err)
-			logger.Log.Infoln(errStr)
-			return Result{
-				Error:    errors.New(errStr),
-				FlowName: name,
-			}
-		}
+	var err error
+	switch {
+	case ct.Plan != nil:
+		result.Action, err = stack.ApplyPlan(ctx, ct.CM, *ct.Plan)
+	case ct.DeployMode && ct.ChangeSetMode:
+		result.Action, err = stack.ApplyChangeSet(ctx, ct.CM, ct.Approve)
+	case ct.DeployMode:
+		result.Action, err = stack.ApplyChanges(ctx, ct.CM)
+	default:
+		result.Action, err = stack.Destroy(ctx, ct.CM)
 	}
-	return Result{FlowName: name}
+	return err
 }
@@ -1,33 +1,649 @@
 package compose
 
 import (
-	"fmt"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/rbalman/cfn-compose/cfn"
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+	"github.com/rbalman/cfn-compose/config"
+	"github.com/rbalman/cfn-compose/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
-func TestValidateComposeConfig(t *testing.T) {
-	t.Log("When there are no items")
+const testComposeFile = `Description: Test compose file
+Vars:
+  ENV_NAME: test
+Flows:
+  Network:
+    Order: 0
+    Stacks:
+    - template_file: template.yml
+      stack_name: '{{ .ENV_NAME }}-sg'
+  App:
+    Order: 1
+    Stacks:
+    - template_file: template.yml
+      stack_name: '{{ .ENV_NAME }}-ec2'
+      parameters:
+        EnvironmentName: '{{ .ENV_NAME }}'
+    - template_file: template.yml
+      stack_name: '{{ .ENV_NAME }}-alarm'
+`
+
+// testTemplate declares the parameters given by the test compose files
+const testTemplate = `Parameters:
+  EnvironmentName:
+    Type: String
+    Default: test
+  SecurityGroupId:
+    Type: String
+    Default: ""
+Resources: {}
+`
+
+// writeComposeFile writes the compose file and its template into a temporary
+// directory.
+func writeComposeFile(t *testing.T, compose string) string {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "template.yml"), []byte(testTemplate), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "cfn-compose.yml")
+	if err := os.WriteFile(path, []byte(compose), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApply(t *testing.T) {
+	t.Log("When deploying all flows")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		configFile := writeComposeFile(t, testComposeFile)
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: configFile, CFNClient: fake}
+		c.Apply()
+
+		calls := fake.Calls()
+		expected := []string{"CreateStack:test-sg", "CreateStack:test-ec2", "CreateStack:test-alarm"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected calls %v but got %v", expected, calls)
+		}
+		if _, err := os.Stat(config.StateDir(configFile)); !os.IsNotExist(err) {
+			t.Fatalf("Expected the rendered compose file to be saved only with a StateDir but got %v", err)
+		}
+
+		for _, name := range []string{"test-sg", "test-ec2", "test-alarm"} {
+			if status := fake.StackStatus(name); status != "CREATE_COMPLETE" {
+				t.Fatalf("Expected %s status to be CREATE_COMPLETE but got %s", name, status)
+			}
+		}
+	}
+
+	t.Log("When destroying all flows")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		for _, name := range []string{"test-sg", "test-ec2", "test-alarm"} {
+			fake.PutStack(name, "CREATE_COMPLETE")
+		}
+
+		c := Composer{LogLevel: "ERROR", DeployMode: false, ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake}
+		c.Apply()
+
+		calls := fake.Calls()
+		expected := []string{"DeleteStack:test-alarm", "DeleteStack:test-ec2", "DeleteStack:test-sg"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected calls %v but got %v", expected, calls)
+		}
+	}
+
+	t.Log("When a stack fails the following orders are not deployed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Fail("test-sg", "SecurityGroup", "Invalid VPC")
+
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake}
+		if code := ExitCode(c.Apply()); code != ExitStackFailed {
+			t.Fatalf("Expected exit code %d but got %d", ExitStackFailed, code)
+		}
+
+		calls := fake.Calls()
+		expected := []string{"CreateStack:test-sg"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected calls %v but got %v", expected, calls)
+		}
+	}
+
+	t.Log("When the compose file is invalid")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, "Flows: {}"), CFNClient: fake, CherryPickedFlow: "Missing"}
+		if code := ExitCode(c.Apply()); code != ExitConfig {
+			t.Fatalf("Expected exit code %d but got %d", ExitConfig, code)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no calls but got %v", fake.Calls())
+		}
+	}
+
+	t.Log("When the stack parameters don't match their template")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		compose := strings.Replace(testComposeFile, "EnvironmentName:", "EnvironmentNam:", 1)
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, compose), CFNClient: fake}
+		err := c.Apply()
+		if code := ExitCode(err); code != ExitConfig || !strings.Contains(err.Error(), "parameter EnvironmentNam is not declared in the template") {
+			t.Fatalf("Expected exit code %d for the unknown parameter but got %d: %v", ExitConfig, code, err)
+		}
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no calls but got %v", fake.Calls())
+		}
+	}
+
+	t.Log("When running in dry run mode")
 	{
-		var stacks []cfn.Stack
-		rs := reverseStackOrder(stacks)
-		if len(rs) != 0 {
-			t.Fatal(fmt.Sprintf("Expected reverse stack length to be 0 but got %d", len(rs)))
+		fake := cfntest.NewFakeCloudFormation()
+		c := Composer{LogLevel: "ERROR", DeployMode: true, DryRun: true, ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake}
+		c.Apply()
+
+		if len(fake.Calls()) != 0 {
+			t.Fatalf("Expected no mutating calls but got %v", fake.Calls())
 		}
 	}
+}
 
-	t.Log("When there are items")
+const testDependsOnComposeFile = `Description: Test compose file
+Vars:
+  ENV_NAME: test
+Flows:
+  Network:
+    Order: 0
+    Stacks:
+    - template_file: template.yml
+      stack_name: vpc
+    - template_file: template.yml
+      stack_name: slow
+  Database:
+    Order: 1
+    Stacks:
+    - template_file: template.yml
+      stack_name: rds
+      depends_on:
+      - vpc
+`
+
+func TestApplyDependsOn(t *testing.T) {
+	t.Log("When a stack only depends on a completed stack it doesn't wait for the slowest flow")
 	{
-		stacks := []cfn.Stack{{StackName: "first"}, {StackName: "second"}, {StackName: "third"}}
-		rs := reverseStackOrder(stacks)
-		j := 0
-		for i := len(stacks) - 1; i >= 0; i-- {
-			sName := stacks[i].StackName
-			rsName := rs[j].StackName
-			if sName != rsName {
-				t.Fatal(fmt.Sprintf("Expected stacks[%d] and rs[%d] to be equal but got %s and %s", i, j, sName, rsName))
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetLatency("slow", 3*time.Second)
+
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testDependsOnComposeFile), CFNClient: fake}
+		done := make(chan bool)
+		go func() {
+			c.Apply()
+			done <- true
+		}()
+
+		deadline := time.After(10 * time.Second)
+		for fake.StackStatus("rds") != "CREATE_COMPLETE" {
+			select {
+			case <-deadline:
+				t.Fatal("Timed out waiting for rds to be created")
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		if status := fake.StackStatus("slow"); status != "CREATE_IN_PROGRESS" {
+			t.Fatalf("Expected slow to be still in CREATE_IN_PROGRESS but got %s", status)
+		}
+		<-done
+	}
+
+	t.Log("When destroying, a stack waits for its dependents to be deleted")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		for _, name := range []string{"vpc", "slow", "rds"} {
+			fake.PutStack(name, "CREATE_COMPLETE")
+		}
+
+		c := Composer{LogLevel: "ERROR", DeployMode: false, ConfigFile: writeComposeFile(t, testDependsOnComposeFile), CFNClient: fake}
+		c.Apply()
+
+		calls := fake.Calls()
+		expected := []string{"DeleteStack:slow", "DeleteStack:rds", "DeleteStack:vpc"}
+		if len(calls) != 3 || calls[2] != "DeleteStack:vpc" {
+			t.Fatalf("Expected calls %v (vpc last) but got %v", expected, calls)
+		}
+	}
+}
+
+const testOutputsComposeFile = `Description: Test compose file
+Vars:
+  ENV_NAME: test
+Flows:
+  App:
+    Order: 0
+    Stacks:
+    - template_file: template.yml
+      stack_name: '{{ .ENV_NAME }}-ec2'
+      parameters:
+        SecurityGroupId: '{{ output (printf "%s-sg" .ENV_NAME) "GroupId" }}'
+  Network:
+    Order: 0
+    Stacks:
+    - template_file: template.yml
+      stack_name: '{{ .ENV_NAME }}-sg'
+`
+
+func TestApplyOutputs(t *testing.T) {
+	t.Log("When a parameter references the output of another stack")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetOutputs("test-sg", map[string]string{"GroupId": "sg-123"})
+
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testOutputsComposeFile), CFNClient: fake}
+		c.Apply()
+
+		calls := fake.Calls()
+		expected := []string{"CreateStack:test-sg", "CreateStack:test-ec2"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected calls %v but got %v", expected, calls)
+		}
+
+		_, params := fake.StackInput("test-ec2")
+		if params["SecurityGroupId"] != "sg-123" {
+			t.Fatalf("Expected parameter SecurityGroupId to be sg-123 but got %s", params["SecurityGroupId"])
+		}
+	}
+}
+
+func TestApplyPlanOut(t *testing.T) {
+	t.Log("When the dry run plan is written to a file")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		planOut := filepath.Join(t.TempDir(), "plan.json")
+
+		c := Composer{LogLevel: "ERROR", DeployMode: true, DryRun: true, PlanOut: planOut, ConfigFile: writeComposeFile(t, testOutputsComposeFile), CFNClient: fake}
+		c.Apply()
+
+		data, err := os.ReadFile(planOut)
+		if err != nil {
+			t.Fatalf("Expected the plan file to be written but got error: %s", err)
+		}
+
+		var plan Plan
+		if err := json.Unmarshal(data, &plan); err != nil {
+			t.Fatalf("Expected the plan file to be valid JSON but got error: %s", err)
+		}
+
+		if plan.Version != 1 || plan.Mode != "deploy" || len(plan.Stacks) != 2 {
+			t.Fatalf("Expected a deploy plan of 2 stacks but got %+v", plan)
+		}
+
+		ec2, sg := plan.Stacks[0], plan.Stacks[1]
+		if ec2.StackName != "test-ec2" || ec2.FlowName != "App" || ec2.Action != cfn.PlanBlocked || ec2.Status != "DOESN'T EXIST" || ec2.Reason == "" {
+			t.Fatalf("Expected test-ec2 to be blocked by the missing outputs but got %+v", ec2)
+		}
+		if sg.StackName != "test-sg" || sg.FlowName != "Network" || sg.Action != cfn.PlanCreate {
+			t.Fatalf("Expected test-sg to be created but got %+v", sg)
+		}
+		if plan.Summary.Create != 1 || plan.Summary.Blocked != 1 {
+			t.Fatalf("Expected 1 create and 1 blocked stack in the summary but got %+v", plan.Summary)
+		}
+	}
+}
+
+const testPlanComposeFile = `Description: Test compose file
+Flows:
+  Queue:
+    Stacks:
+    - template_file: template.yml
+      stack_name: sqs
+`
+
+func TestApplyPlanFile(t *testing.T) {
+	t.Log("When the saved plan is applied")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		configFile := writeComposeFile(t, testPlanComposeFile)
+		planFile := filepath.Join(t.TempDir(), "plan.json")
+
+		c := Composer{LogLevel: "ERROR", DeployMode: true, DryRun: true, KeepChangeSets: true, PlanOut: planFile, ConfigFile: configFile, CFNClient: fake}
+		c.Apply()
+
+		c = Composer{LogLevel: "ERROR", DeployMode: true, PlanFile: planFile, ConfigFile: configFile, CFNClient: fake}
+		c.Apply()
+
+		calls := fake.Calls()
+		expected := []string{"CreateChangeSet:sqs", "ExecuteChangeSet:sqs"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected calls %v but got %v", expected, calls)
+		}
+		if status := fake.StackStatus("sqs"); status != "CREATE_COMPLETE" {
+			t.Fatalf("Expected stack status to be CREATE_COMPLETE but got %s", status)
+		}
+	}
+}
+
+const testConcurrencyComposeFile = `Description: Test compose file
+Concurrency: 1
+Vars:
+  ENV_NAME: test
+Flows:
+  Queue:
+    Stacks:
+    - template_file: template.yml
+      stack_name: sqs
+  Topic:
+    Stacks:
+    - template_file: template.yml
+      stack_name: sns
+`
+
+func TestApplyConcurrency(t *testing.T) {
+	t.Log("When the concurrency is limited to one stack")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetLatency("sqs", time.Second)
+		fake.SetLatency("sns", time.Second)
+
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testConcurrencyComposeFile), CFNClient: fake}
+		done := make(chan bool)
+		go func() {
+			c.Apply()
+			done <- true
+		}()
+
+		deadline := time.After(10 * time.Second)
+		for fake.StackStatus("sqs") != "CREATE_IN_PROGRESS" && fake.StackStatus("sns") != "CREATE_IN_PROGRESS" {
+			select {
+			case <-deadline:
+				t.Fatal("Timed out waiting for a stack to be in progress")
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+
+		if len(fake.Calls()) != 1 {
+			t.Fatalf("Expected only one stack to be in progress but got calls %v", fake.Calls())
+		}
+		<-done
+
+		if len(fake.Calls()) != 2 {
+			t.Fatalf("Expected both stacks to be created but got calls %v", fake.Calls())
+		}
+	}
+
+	t.Log("When computing the worker count")
+	{
+		cases := []struct {
+			flag, config, stacks, expected int
+		}{
+			{0, 0, 5, 5},
+			{0, 2, 5, 2},
+			{3, 2, 5, 3},
+			{10, 0, 5, 5},
+		}
+
+		for _, c := range cases {
+			if count := workerCount(c.flag, c.config, c.stacks); count != c.expected {
+				t.Fatalf("Expected worker count %d for %+v but got %d", c.expected, c, count)
+			}
+		}
+	}
+}
+
+const testTimeoutComposeFile = `Description: Test compose file
+Flows:
+  App:
+    Order: 0
+    Timeout: 1s
+    Stacks:
+    - template_file: template.yml
+      stack_name: slow
+    - template_file: template.yml
+      stack_name: next
+`
+
+func TestApplyTimeout(t *testing.T) {
+	t.Log("When the flow timeout expires while its first stack is deployed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetLatency("slow", 10*time.Second)
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testTimeoutComposeFile), CFNClient: fake}
+
+		start := time.Now()
+		c.Apply()
+
+		if elapsed := time.Since(start); elapsed > 9*time.Second {
+			t.Fatalf("Expected the run to stop at the flow timeout but it took %s", elapsed)
+		}
+		calls := fake.Calls()
+		if !reflect.DeepEqual(calls, []string{"CreateStack:slow"}) {
+			t.Fatalf("Expected only the slow stack to be created but got calls %v", calls)
+		}
+	}
+
+	t.Log("When the run timeout expires")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.SetLatency("test-sg", 10*time.Second)
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake, Timeout: time.Second}
+
+		start := time.Now()
+		c.Apply()
+
+		if elapsed := time.Since(start); elapsed > 9*time.Second {
+			t.Fatalf("Expected the run to stop at the run timeout but it took %s", elapsed)
+		}
+		calls := fake.Calls()
+		if !reflect.DeepEqual(calls, []string{"CreateStack:test-sg"}) {
+			t.Fatalf("Expected only the first stack to be created but got calls %v", calls)
+		}
+	}
+}
+
+func TestApplyInterrupt(t *testing.T) {
+	t.Log("When the run is interrupted while a stack is updated")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		for _, name := range []string{"test-sg", "test-ec2", "test-alarm"} {
+			fake.PutStack(name, "CREATE_COMPLETE")
+		}
+		fake.SetLatency("test-sg", 10*time.Second)
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake, CancelUpdates: true}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(time.Second, cancel)
+
+		start := time.Now()
+		if code := ExitCode(c.ApplyContext(ctx)); code != ExitInterrupted {
+			t.Fatalf("Expected exit code %d but got %d", ExitInterrupted, code)
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("Expected the run to stop once interrupted but it took %s", elapsed)
+		}
+		calls := fake.Calls()
+		expected := []string{"UpdateStack:test-sg", "CancelUpdateStack:test-sg"}
+		if !reflect.DeepEqual(calls, expected) {
+			t.Fatalf("Expected calls %v but got %v", expected, calls)
+		}
+	}
+}
+
+const testContinueComposeFile = `Description: Test compose file
+Flows:
+  Network:
+    Order: 0
+    Stacks:
+    - template_file: template.yml
+      stack_name: vpc
+  Storage:
+    Order: 0
+    Stacks:
+    - template_file: template.yml
+      stack_name: bucket
+    - template_file: template.yml
+      stack_name: bucket-policy
+  App:
+    Order: 1
+    Stacks:
+    - template_file: template.yml
+      stack_name: ec2
+`
+
+func TestApplyContinueOnError(t *testing.T) {
+	t.Log("When a stack fails and the run continues on error")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Fail("vpc", "VPC", "Invalid CIDR")
+		c := Composer{LogLevel: "ERROR", DeployMode: true, ConfigFile: writeComposeFile(t, testContinueComposeFile), CFNClient: fake, ContinueOnError: true}
+
+		if code := ExitCode(c.Apply()); code != ExitPartial {
+			t.Fatalf("Expected exit code %d but got %d", ExitPartial, code)
+		}
+		for _, name := range []string{"bucket", "bucket-policy"} {
+			if status := fake.StackStatus(name); status != "CREATE_COMPLETE" {
+				t.Fatalf("Expected %s status to be CREATE_COMPLETE but got %s", name, status)
+			}
+		}
+		for _, call := range fake.Calls() {
+			if call == "CreateStack:ec2" {
+				t.Fatalf("Expected the dependents of the failed stack not to be deployed but got calls %v", fake.Calls())
+			}
+		}
+	}
+}
+
+func TestComposerLibrary(t *testing.T) {
+	ctx := context.Background()
+	template := writeComposeFile(t, "Flows: {}")
+	template = filepath.Join(filepath.Dir(template), "template.yml")
+	cc := config.ComposeConfig{
+		Vars: map[string]string{"AWS_REGION": "eu-west-1"},
+		Flows: map[string]config.Flow{
+			"Network": {Order: 0, Stacks: []cfn.Stack{{StackName: "vpc", TemplateFile: template}}},
+			"App":     {Order: 1, Stacks: []cfn.Stack{{StackName: "api", TemplateFile: template}}},
+		},
+	}
+
+	t.Log("When the stacks are deployed from a compose config value")
+	{
+		wd, _ := os.Getwd()
+		region, regionSet := os.LookupEnv("AWS_REGION")
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Throttle("CreateStack", 1)
+		var logs bytes.Buffer
+		l := logger.New(&logs, &logs, logger.INFO)
+		var events []Event
+		c := Composer{Config: &cc, CFNClient: fake, Logger: &l, Output: io.Discard, Retry: cfn.RetryPolicy{BaseDelay: time.Millisecond}, OnEvent: func(e Event) { events = append(events, e) }}
+
+		summary, err := c.Deploy(ctx)
+		if err != nil {
+			t.Fatalf("Deploy should return nil but found error: %s", err)
+		}
+		if summary.Count(OutcomeCreated) != 2 {
+			t.Fatalf("Expected 2 stacks to be created but got %s", summary.Counts())
+		}
+		var finished []Event
+		for _, e := range events {
+			if e.Type == EventStackFinished || e.Type == EventRunFinished {
+				finished = append(finished, e)
+			}
+		}
+		if len(finished) != 3 || finished[0].StackName != "vpc" || finished[0].Outcome != OutcomeCreated || finished[2].Type != EventRunFinished {
+			t.Fatalf("Expected the stack and run events but got %+v", events)
+		}
+		if !strings.Contains(logs.String(), "[STACK: vpc]") {
+			t.Fatalf("Expected the logs to be written to the given logger but got:\n%s", logs.String())
+		}
+		if !strings.Contains(logs.String(), "[STACK: vpc] CreateStack failed with a retryable error") {
+			t.Fatalf("Expected the retries of the CloudFormation calls to be logged to the given logger but got:\n%s", logs.String())
+		}
+		if logger.Log.Info != nil {
+			t.Fatal("Expected the global logger to be left untouched")
+		}
+		if now, _ := os.Getwd(); now != wd {
+			t.Fatalf("Expected the working directory to stay %s but got %s", wd, now)
+		}
+		if now, ok := os.LookupEnv("AWS_REGION"); now != region || ok != regionSet {
+			t.Fatalf("Expected AWS_REGION to be left untouched but got %s", now)
+		}
+	}
+
+	t.Log("When a stack update is rejected")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("vpc", "CREATE_COMPLETE")
+		fake.RejectUpdate("vpc", "Template format error: Unresolved resource dependencies [Queue] in the Resources block of the template")
+		l := logger.New(io.Discard, io.Discard, logger.ERROR)
+		c := Composer{Config: &cc, CFNClient: fake, Logger: &l, Output: io.Discard}
+
+		summary, err := c.Deploy(ctx)
+		var runErr *RunError
+		if !errors.As(err, &runErr) || runErr.Code != ExitStackFailed {
+			t.Fatalf("Expected a run error of code %d but got %v", ExitStackFailed, err)
+		}
+		if len(summary.Stacks) == 0 || summary.Stacks[0].StackName != "vpc" || summary.Stacks[0].Outcome != OutcomeFailed {
+			t.Fatalf("Expected vpc to be failed but got %+v", summary.Stacks)
+		}
+		if summary.Count(OutcomeNoChange) != 0 {
+			t.Fatalf("Expected the rejected update not to be reported as no change but got %s", summary.Counts())
+		}
+	}
+
+	t.Log("When the stacks are planned then destroyed")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.PutStack("vpc", "CREATE_COMPLETE")
+		l := logger.New(io.Discard, io.Discard, logger.ERROR)
+		c := Composer{Config: &cc, CFNClient: fake, Logger: &l, Output: io.Discard}
+
+		plan, err := c.Plan(ctx)
+		if err != nil {
+			t.Fatalf("Plan should return nil but found error: %s", err)
+		}
+		if len(plan.Stacks) != 2 || len(fake.Calls()) != 0 {
+			t.Fatalf("Expected the 2 stacks to be planned without changes but got %+v and calls %v", plan.Stacks, fake.Calls())
+		}
+
+		summary, err := c.Destroy(ctx)
+		if err != nil {
+			t.Fatalf("Destroy should return nil but found error: %s", err)
+		}
+		if summary.Count(OutcomeDeleted) != 1 || summary.Count(OutcomeSkipped) != 1 {
+			t.Fatalf("Expected vpc to be deleted and api to be skipped but got %s", summary.Counts())
+		}
+	}
+}
+
+func TestApplyLogDir(t *testing.T) {
+	t.Log("When the logs of the run are written to a directory")
+	{
+		dir := t.TempDir()
+		l := logger.New(io.Discard, io.Discard, logger.INFO)
+		c := Composer{ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: cfntest.NewFakeCloudFormation(), Logger: &l, Output: io.Discard, LogDir: dir}
+		if _, err := c.Deploy(context.Background()); err != nil {
+			t.Fatalf("Deploy should return nil but found error: %s", err)
+		}
+
+		runs, _ := filepath.Glob(filepath.Join(dir, "*-deploy"))
+		if len(runs) != 1 {
+			t.Fatalf("Expected a directory for the run but got %v", runs)
+		}
+		for _, name := range []string{"run.log", "flows/Network.log", "flows/App.log", "stacks/test-sg.log", "stacks/test-ec2.log", "stacks/test-alarm.log"} {
+			if data, err := os.ReadFile(filepath.Join(runs[0], name)); err != nil || len(data) == 0 {
+				t.Fatalf("Expected %s to hold the logs but got %q, error: %v", name, data, err)
 			}
-			j++
 		}
 	}
 }
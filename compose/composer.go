@@ -2,25 +2,40 @@ package compose
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/rbalman/cfn-compose/cfn"
 	"github.com/rbalman/cfn-compose/config"
 	"github.com/rbalman/cfn-compose/libs"
 	"github.com/rbalman/cfn-compose/logger"
 	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// var colors []string = []string{log.Blue, log.Yellow, log.Green, log.Magenta, log.Cyan}
-
 type Task interface {
 	Execute(context.Context) Result
 }
 
 type Result struct {
-	FlowName string
-	Error    error
+	FlowName  string
+	Order     int
+	StackName string
+	Error     error
+	// Plan is set by the dry runs
+	Plan *cfn.StackPlan
+	// Action is the action taken on the stack, one of the cfn Plan* actions
+	Action   string
+	Duration time.Duration
 }
 
 type Composer struct {
@@ -29,97 +44,463 @@ type Composer struct {
 	DeployMode       bool
 	DryRun           bool
 	ConfigFile       string
+	// Concurrency caps the number of stacks deployed at the same time, overrides the compose file value when > 0
+	Concurrency int
+	// Retry overrides the non zero values of the compose file retry policy
+	Retry cfn.RetryPolicy
+	// CFNClient is used instead of a client built from the AWS session when set
+	CFNClient cloudformationiface.CloudFormationAPI
+	// PlanOut is the file the dry run plan is written to as JSON, if set
+	PlanOut string
+	// KeepChangeSets keeps the change sets created by the dry run so that the plan can be applied with PlanFile
+	KeepChangeSets bool
+	// PlanFile is a plan written by a dry run with KeepChangeSets, its change sets are executed instead of computing the changes
+	PlanFile string
+	// ChangeSetMode updates the stacks through change sets, each one is confirmed interactively unless AutoApprove is set
+	ChangeSetMode bool
+	AutoApprove   bool
+	// Timeout bounds the whole compose run, 0 means no limit
+	Timeout time.Duration
+	// CancelUpdates cancels the stack updates in progress when the run is interrupted
+	CancelUpdates bool
+	// ContinueOnError keeps deploying the stacks not depending on a failed one instead of stopping the run
+	ContinueOnError bool
+
+	// Config is used instead of reading ConfigFile when set
+	Config *config.ComposeConfig
+	// StateDir receives the rendered ConfigFile, nothing is saved when empty, the CLI uses config.StateDir
+	StateDir string
+	// Session builds the CloudFormation client when CFNClient isn't set, a session is created from the AWS_PROFILE and AWS_REGION vars otherwise
+	Session *session.Session
+	// Logger receives the logs of the run, including the ones of the cfn package, a logger is created from LogLevel when nil
+	Logger *logger.Logger
+	// OnEvent is called with the events of the run, one at a time and aside from the run, before the run returns.
+	// The events it is more than 1024 events behind on are dropped with a warning, run_started and run_finished never are
+	OnEvent func(Event)
+	// Sinks receive the events of the run along with OnEvent
+	Sinks []Sink
+	// Approve confirms the change sets of ChangeSetMode instead of prompting on stdin
+	Approve cfn.Approver
+	// Output receives the rendered plans and summary, os.Stdout when nil
+	Output io.Writer
+	// LogFormat of the created logger is text, the default, or json
+	LogFormat string
+	// Color gives every flow its own color in the text logs of the created logger, for terminals
+	Color bool
+	// LogDir receives a directory per run with the logs of the run, of every flow and of every stack
+	LogDir string
+	// UI renders a live dashboard of the stacks to Output instead of the logs, the errors are logged once the run is over
+	UI bool
+}
+
+func (c *Composer) Apply() error {
+	return c.ApplyContext(context.Background())
+}
+
+// Deploy deploys the stacks and returns the outcome of every stack, whatever DeployMode and DryRun
+func (c *Composer) Deploy(ctx context.Context) (Summary, error) {
+	d := *c
+	d.DeployMode, d.DryRun = true, false
+	summary, _, err := d.run(ctx)
+	return summary, err
+}
+
+// Destroy deletes the stacks in the reverse order and returns the outcome of every stack, whatever DeployMode and DryRun
+func (c *Composer) Destroy(ctx context.Context) (Summary, error) {
+	d := *c
+	d.DeployMode, d.DryRun = false, false
+	summary, _, err := d.run(ctx)
+	return summary, err
+}
+
+// Plan returns the changes of a deploy, or of a destroy when DeployMode is false, without applying them
+func (c *Composer) Plan(ctx context.Context) (Plan, error) {
+	d := *c
+	d.DryRun = true
+	_, plan, err := d.run(ctx)
+	return plan, err
+}
+
+/*
+ApplyContext runs the compose file until ctx is canceled, e.g. on SIGINT. Once canceled the waits of the running
+stacks are aborted, their updates are canceled when CancelUpdates is set, and the stacks not started yet are skipped.
+A summary of the outcome of every stack is printed once the run is over. When the run didn't complete the returned
+error is a *RunError holding the summary and the exit code of the command, see ExitCode.
+*/
+func (c *Composer) ApplyContext(parent context.Context) error {
+	_, _, err := c.run(parent)
+	return err
 }
 
-func (c *Composer) Apply() {
-	ctx := context.Background()
+// run runs the compose config and returns the outcome of every stack along with the plan of the dry runs
+func (c *Composer) run(parent context.Context) (Summary, Plan, error) {
+	var summary Summary
+	var plan Plan
+
+	if c.LogFormat != "" && c.LogFormat != logger.FormatText && c.LogFormat != logger.FormatJSON {
+		return summary, plan, configError("The log format should be one of %s, %s, found: %s", logger.FormatText, logger.FormatJSON, c.LogFormat)
+	}
+
+	log := c.logger()
+	if c.LogDir != "" {
+		files, err := logger.OpenFiles(filepath.Join(c.LogDir, time.Now().Format("20060102-150405")+"-"+c.mode()))
+		if err != nil {
+			return summary, plan, fmt.Errorf("Failed while creating the log directory: %w", err)
+		}
+		defer files.Close()
+		log = log.WithFiles(files)
+		log.Infof("Writing the logs of the run to %s\n", files.Dir)
+	}
+	out := c.output()
+
+	// the dashboard redraws itself over the terminal, the logs would break it
+	var ui *dashboard
+	// the auto approved change sets are printed along with the logs, once the run is over with the dashboard
+	changesOut := out
+	if c.UI {
+		if c.ChangeSetMode && !c.AutoApprove && c.Approve == nil {
+			return summary, plan, configError("The dashboard can't prompt for the change sets, use --auto-approve with --ui")
+		}
+		held := &heldWriter{w: os.Stderr}
+		defer held.release()
+		log = log.WithOutput(io.Discard, held)
+		changesOut = held
+		ui = newDashboard(out)
+		defer ui.Stop()
+	}
+	parent = logger.WithLogger(parent, log)
+
+	ctx := parent
+	if c.Timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, c.Timeout)
+		defer cancelTimeout()
+	}
 	ctx, cancelCtx := context.WithCancel(ctx)
 	defer cancelCtx()
 
-	cc, err := config.GetComposeConfig(c.ConfigFile)
-	if err != nil {
-		fmt.Printf("Err: Failed to Parse Compose Config: %s\n", err)
-		os.Exit(1)
+	if c.PlanOut != "" && !c.DryRun {
+		return summary, plan, configError("The plan can only be written in dry run mode, use --dry-run with --plan-out")
+	}
+
+	if c.KeepChangeSets && (!c.DryRun || !c.DeployMode) {
+		return summary, plan, configError("The change sets can only be kept by the deploy dry run")
 	}
 
-	err = cc.Validate()
-	if err != nil {
-		fmt.Printf("Err: Failed While Validating Compose Config: %s\n", err)
-		os.Exit(1)
+	if c.ChangeSetMode && (c.DryRun || !c.DeployMode || c.PlanFile != "") {
+		return summary, plan, configError("The change set mode is only supported by deploy without dry run")
 	}
 
-	logger.StartWithLabel(c.LogLevel)
+	var savedPlan Plan
+	if c.PlanFile != "" {
+		if c.DryRun || !c.DeployMode {
+			return summary, plan, configError("The plan can only be applied in deploy mode without dry run")
+		}
 
-	var flowsMap map[int][]config.Flow
-	if c.CherryPickedFlow != "" {
-		flowsMap = cherryPickFlow(c.CherryPickedFlow, cc.Flows)
-		if len(flowsMap) == 0 {
-			fmt.Printf("Err: Cannot find the selected flow: %s in the config\n", c.CherryPickedFlow)
-			os.Exit(1)
+		var err error
+		savedPlan, err = ReadPlanFile(c.PlanFile)
+		if err != nil {
+			return summary, plan, configError("Failed to Read the Plan: %w", err)
+		}
+	}
+
+	var cc config.ComposeConfig
+	if c.Config != nil {
+		cc = *c.Config
+		if err := cc.LoadParametersFiles(); err != nil {
+			return summary, plan, configError("Failed to Parse Compose Config: %w", err)
 		}
 	} else {
-		flowsMap = SortFlows(cc.Flows)
+		var err error
+		cc, err = config.LoadComposeConfig(c.ConfigFile, c.StateDir)
+		if err != nil {
+			return summary, plan, configError("Failed to Parse Compose Config: %w", err)
+		}
 	}
 
-	orders := keys(flowsMap)
-	if c.DeployMode {
-		sort.Ints(orders)
+	if err := cc.Validate(); err != nil {
+		return summary, plan, configError("Failed While Validating Compose Config: %w", err)
+	}
+
+	var flows map[string]config.Flow
+	if c.CherryPickedFlow != "" {
+		flows = cherryPickFlow(c.CherryPickedFlow, cc.Flows)
+		if len(flows) == 0 {
+			return summary, plan, configError("Cannot find the selected flow: %s in the config", c.CherryPickedFlow)
+		}
+	} else if c.PlanFile != "" {
+		flows = plannedFlows(savedPlan, cc.Flows)
 	} else {
-		sort.Sort(sort.Reverse(sort.IntSlice(orders)))
+		flows = cc.Flows
+	}
+
+	// the parameters of the stacks to deploy are checked before touching any of them, the saved plans already hold theirs
+	if c.DeployMode && c.PlanFile == "" {
+		selected := config.ComposeConfig{Flows: flows}
+		if err := selected.ValidateParameters(); err != nil {
+			return summary, plan, configError("Failed While Validating the Stack Parameters: %w", err)
+		}
 	}
 
-	// Exporting AWS_PROFILE and AWS_REGION got from config
-	if val, ok := cc.Vars["AWS_PROFILE"]; ok {
-		os.Setenv("AWS_PROFILE", val)
+	graph := config.NewGraph(flows)
+	if !c.DeployMode {
+		graph = graph.Reverse()
 	}
 
-	if val, ok := cc.Vars["AWS_REGION"]; ok {
-		os.Setenv("AWS_REGION", val)
+	if c.PlanFile != "" {
+		if err := savedPlan.Validate(graph.Names()); err != nil {
+			return summary, plan, configError("The Plan can't be applied: %w", err)
+		}
+		savedPlan.Render(out)
+	}
+
+	// AWS_PROFILE and AWS_REGION of the compose config select the session, the environment is left untouched
+	client := c.CFNClient
+	region := cc.Vars["AWS_REGION"]
+	if client == nil {
+		sess := c.Session
+		if sess == nil {
+			var err error
+			sess, err = libs.GetAWSSession(cc.Vars["AWS_PROFILE"], region)
+			if err != nil {
+				return summary, plan, &RunError{Code: ExitAuth, Err: fmt.Errorf("Failed while creating AWS Session: %w", err)}
+			}
+		}
+		client = cloudformation.New(sess)
+		region = aws.StringValue(sess.Config.Region)
+	}
+	sinks := c.Sinks
+	if ui != nil {
+		sinks = append(sinks[:len(sinks):len(sinks)], ui)
 	}
+	if c.OnEvent != nil {
+		sinks = append(sinks[:len(sinks):len(sinks)], SinkFunc(func(e Event) error {
+			c.OnEvent(e)
+			return nil
+		}))
+	}
+	events := NewEventBus(log, sinks...)
+	defer events.Close()
 
-	sess, err := libs.GetAWSSession()
-	if err != nil {
-		logger.Log.Errorf("Failed while creating AWS Session: %s\n", err.Error())
-		os.Exit(1)
+	cm := cfn.CFNManager{Client: client, Retry: cc.Retry.Merge(c.Retry), Region: region}
+	cm.OnStackEvent = func(ctx context.Context, e *cloudformation.StackEvent) {
+		events.Emit(stackEvent(ctx, e))
 	}
-	cm := cfn.CFNManager{Session: sess}
 
-	cfnTask := make(chan Task)
+	names := graph.Names()
+	concurrency := workerCount(c.Concurrency, cc.Concurrency, len(names))
+	cfnTask := make(chan Task, len(names))
 	resultsChan := make(chan Result)
-	//Generate the worker pool as pre the flow counts
-	for i := 0; i < len(cc.Flows); i++ {
-		go executeFlow(ctx, cfnTask, resultsChan, i)
+	// the workers are waited for so that nothing of the run is left behind once it returns
+	var workers sync.WaitGroup
+	defer func() {
+		close(cfnTask)
+		workers.Wait()
+	}()
+	//Generate the worker pool as per the concurrency limit
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func(workerId int) {
+			defer workers.Done()
+			executeTask(ctx, cfnTask, resultsChan, workerId)
+		}(i)
 	}
-	logger.Log.Debugf("TOTAL FLOW COUNT: %d\n", len(cc.Flows))
-	//Dispatch Flows based on the Order
-	for _, order := range orders {
-		flows, ok := flowsMap[order]
-		if !ok {
+	log.Debugf("TOTAL FLOW COUNT: %d, TOTAL STACK COUNT: %d, CONCURRENCY: %d\n", len(flows), len(names), concurrency)
+
+	remaining := make(map[string]int)
+	flowStacks := make(map[string]int)
+	for _, name := range names {
+		node := graph.Nodes[name]
+		remaining[name] = len(node.DependsOn)
+		flowStacks[node.FlowName]++
+	}
+
+	approve := c.Approve
+	switch {
+	case !c.ChangeSetMode || approve != nil:
+	case c.AutoApprove:
+		approve = newAutoApprover(changesOut).Approve
+	default:
+		approve = newPromptApprover(ctx, os.Stdin, out).Approve
+	}
+
+	summary.DeployMode = c.DeployMode
+	running := 0
+	inFlight := make(map[string]bool)
+	flowDeadlines := make(map[string]time.Time)
+	startedOrders := make(map[int]bool)
+	startedFlows := make(map[string]bool)
+	dispatch := func(name string) {
+		node := graph.Nodes[name]
+		if !startedOrders[node.Order] {
+			startedOrders[node.Order] = true
+			events.Emit(Event{Type: EventOrderStarted, Order: node.Order})
+		}
+		if !startedFlows[node.FlowName] {
+			startedFlows[node.FlowName] = true
+			events.Emit(Event{Type: EventFlowStarted, FlowName: node.FlowName, Order: node.Order})
+		}
+
+		task := CfnTask{FlowName: node.FlowName, Order: node.Order, Stack: node.Stack, DryRun: c.DryRun, DeployMode: c.DeployMode, CM: cm, KeepChangeSet: c.KeepChangeSets, ChangeSetMode: c.ChangeSetMode, Approve: approve, Events: events}
+		if c.PlanFile != "" {
+			task.Plan = savedPlan.stack(name)
+		}
+		// the flow timeout starts with its first stack
+		if timeout := flows[node.FlowName].Timeout; timeout > 0 {
+			if _, ok := flowDeadlines[node.FlowName]; !ok {
+				flowDeadlines[node.FlowName] = time.Now().Add(timeout)
+			}
+			task.Deadline = flowDeadlines[node.FlowName]
+		}
+		cfnTask <- task
+		running++
+		inFlight[name] = true
+		log.Debugf("Dispatched Stack: %s, Flow: %s, Order: %d.\n", name, node.FlowName, node.Order)
+	}
+
+	stacks := make([]StackSummary, 0, len(names))
+	for _, name := range names {
+		node := graph.Nodes[name]
+		stacks = append(stacks, StackSummary{FlowName: node.FlowName, Order: node.Order, StackName: name})
+	}
+	events.Emit(Event{Type: EventRunStarted, Mode: c.mode(), DryRun: c.DryRun, Stacks: stacks})
+
+	//Dispatch Stacks as soon as all of their dependencies are completed
+	for _, name := range names {
+		if remaining[name] == 0 {
+			dispatch(name)
+		}
+	}
+
+	// once stopped no stack is dispatched anymore, the running ones are waited for as their waits are aborted
+	stopped := false
+	done := ctx.Done()
+	for running > 0 {
+		var r Result
+		select {
+		case r = <-resultsChan:
+		case <-done:
+			done = nil
+			stopped = true
+			switch {
+			case parent.Err() != nil:
+				log.Warnf("Interrupted... waiting for the %d running stack(s) to stop\n", running)
+				if c.CancelUpdates {
+					cancelUpdates(graph, inFlight, cm, log)
+				}
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				log.Errorf("Compose run timed out after %s, waiting for the %d running stack(s) to stop\n", c.Timeout, running)
+			}
 			continue
 		}
+		running--
+		delete(inFlight, r.StackName)
+		summary.Add(r, stopped || ctx.Err() != nil)
+		events.Emit(stackFinishedEvent(summary.Stacks[len(summary.Stacks)-1]))
 
-		for _, flow := range flows {
-			cfnTask <- CfnTask{Flow: flow, DryRun: c.DryRun, DeployMode: c.DeployMode, CM: cm}
+		if r.Error != nil {
+			if !stopped && parent.Err() == nil {
+				log.Errorf("Compose failed with Error: %s", r.Error)
+				var serr *cfn.StackError
+				if errors.As(r.Error, &serr) && serr.RootCause() != "" {
+					log.Errorf("Root Cause [STACK: %s] [STATUS: %s]: %s\n", serr.StackName, serr.Status, serr.RootCause())
+				}
+			}
+			if c.ContinueOnError && ctx.Err() == nil {
+				if dependents := graph.Dependents(r.StackName); len(dependents) > 0 {
+					log.Warnf("Skipping... the stacks depending on the failed stack %s: %s\n", r.StackName, strings.Join(dependents, ", "))
+				}
+				continue
+			}
+			stopped = true
+			cancelCtx()
+			continue
+		}
+
+		if r.Plan != nil {
+			plan.Add(r)
 		}
 
-		logger.Log.Debugf("Dispatched Order: %d, FlowCount: %d.\n", order, len(flows))
+		flowStacks[r.FlowName]--
+		if flowStacks[r.FlowName] == 0 {
+			log.Infof("All Stacks completed for Flow: %s\n\n", r.FlowName)
+		}
 
-		//Wait for dispatched flows
-		for i := 0; i < len(flows); i++ {
-			//TODO: Add some form of timer for timeout
-			r := <-resultsChan
-			if r.Error != nil {
-				cancelCtx()
-				logger.Log.Debugln("Graceful wait for cancelled flows")
-				time.Sleep(time.Second * 5)
-				logger.Log.Errorf("Compose failed with Error: %s", r.Error)
-				return
+		if stopped {
+			continue
+		}
+		for _, dependent := range graph.Dependents(r.StackName) {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				dispatch(dependent)
 			}
 		}
-		logger.Log.Infof("All Flows completed for Order: %d\n\n", order)
 	}
 
-	logger.Log.Infoln("Successfully Completed!!")
+	summary.AddNotStarted(graph)
+	events.Emit(Event{Type: EventRunFinished, Summary: &summary})
+	// the dashboard renders its last frame before the summary
+	events.Close()
+	ui.Stop()
+	if stopped || summary.Failed() {
+		summary.Render(out)
+		rerr := &RunError{Code: ExitStackFailed, Summary: &summary, Err: fmt.Errorf("compose failed: %s", summary.Counts())}
+		switch {
+		case parent.Err() != nil:
+			log.Errorln("Compose interrupted!!")
+			rerr.Code, rerr.Err = ExitInterrupted, fmt.Errorf("compose interrupted: %s", summary.Counts())
+		case summary.AuthFailed():
+			rerr.Code = ExitAuth
+		case c.ContinueOnError && !stopped && summary.Completed() > 0:
+			rerr.Code = ExitPartial
+		}
+		return summary, plan, rerr
+	}
+
+	if c.DryRun {
+		plan.Render(out)
+		if c.PlanOut != "" {
+			plan.Mode = c.mode()
+			plan.ConfigFile = c.ConfigFile
+			plan.CreatedAt = time.Now().UTC()
+			if err := plan.WriteFile(c.PlanOut); err != nil {
+				return summary, plan, fmt.Errorf("Failed while writing the plan to %s: %w", c.PlanOut, err)
+			}
+			log.Infof("Plan written to %s\n", c.PlanOut)
+		}
+	}
+
+	summary.Render(out)
+	log.Infoln("Successfully Completed!!")
+	return summary, plan, nil
+}
+
+// logger returns the logger of the run, one is created from LogLevel, LogFormat and Color when none is given
+func (c *Composer) logger() logger.Logger {
+	if c.Logger != nil {
+		return *c.Logger
+	}
+	format := c.LogFormat
+	if format == "" {
+		format = logger.FormatText
+	}
+	l := logger.NewWithFormat(os.Stdout, os.Stderr, logger.GetLogLevel(c.LogLevel), format)
+	l.Color = c.Color
+	return l
+}
+
+func (c *Composer) output() io.Writer {
+	if c.Output != nil {
+		return c.Output
+	}
+	return os.Stdout
+}
+
+func (c *Composer) mode() string {
+	if c.DeployMode {
+		return "deploy"
+	}
+	return "destroy"
 }
 
 func SortFlows(flows map[string]config.Flow) map[int][]config.Flow {
@@ -149,7 +530,11 @@ func VisualizeFlowsMap(flowsMap map[int][]config.Flow) {
 		for _, flow := range flows {
 			fmt.Printf("  FLOW: %s\n", flow.Name)
 			for _, stack := range flow.Stacks {
-				fmt.Printf("    Stack: %s\n", stack.StackName)
+				if len(stack.DependsOn) > 0 {
+					fmt.Printf("    Stack: %s (depends on: %s)\n", stack.StackName, strings.Join(stack.DependsOn, ", "))
+				} else {
+					fmt.Printf("    Stack: %s\n", stack.StackName)
+				}
 			}
 		}
 	}
@@ -163,45 +548,67 @@ func keys(flowMap map[int][]config.Flow) []int {
 	return keys
 }
 
-func cherryPickFlow(flowName string, flows map[string]config.Flow) map[int][]config.Flow {
-	cherryPickedFlow := make(map[int][]config.Flow)
+// workerCount returns the number of stacks that can be deployed at the same time.
+// The command line value takes precedence over the compose file, 0 means one worker per stack.
+func workerCount(flagValue, configValue, stackCount int) int {
+	concurrency := configValue
+	if flagValue > 0 {
+		concurrency = flagValue
+	}
+
+	if concurrency <= 0 || concurrency > stackCount {
+		return stackCount
+	}
+	return concurrency
+}
+
+// plannedFlows returns the flows of the compose configuration having stacks in the plan
+func plannedFlows(plan Plan, flows map[string]config.Flow) map[string]config.Flow {
+	planned := make(map[string]config.Flow)
+	for _, stack := range plan.Stacks {
+		if flow, ok := flows[stack.FlowName]; ok {
+			flow.Name = stack.FlowName
+			planned[stack.FlowName] = flow
+		}
+	}
+	return planned
+}
+
+func cherryPickFlow(flowName string, flows map[string]config.Flow) map[string]config.Flow {
+	cherryPickedFlow := make(map[string]config.Flow)
 	for name, flow := range flows {
 		if name == flowName {
 			flow.Name = name
-			cherryPickedFlow[flow.Order] = []config.Flow{flow}
+			cherryPickedFlow[name] = flow
 		}
 	}
 	return cherryPickedFlow
 }
 
-func reverseStackOrder(stacks []cfn.Stack) []cfn.Stack {
-	var rs []cfn.Stack
-	if len(stacks) == 0 {
-		return rs
-	}
-	for i := len(stacks) - 1; i >= 0; i-- {
-		rs = append(rs, stacks[i])
-	}
-	return rs
-}
-
-func executeFlow(ctx context.Context, taskC chan Task, resultsChan chan Result, workerId int) {
+func executeTask(ctx context.Context, taskC chan Task, resultsChan chan Result, workerId int) {
 	defer func() {
-		logger.Log.Debugf("Worker: %d exiting...\n", workerId)
+		logger.FromContext(ctx).DebugCtxf(ctx, "Worker: %d exiting...\n", workerId)
 	}()
 
-	for {
+	// the tasks dispatched before the run was stopped are still executed, they report that they didn't start
+	for task := range taskC {
 		select {
-		case task := <-taskC:
-			time.Sleep(time.Millisecond * 500)
-			result := task.Execute(ctx)
-			resultsChan <- result
-
+		case <-time.After(time.Millisecond * 500):
 		case <-ctx.Done():
-			if err := ctx.Err(); err != nil {
-				logger.Log.DebugCtxf(ctx, "Cancel signal received Worker: %d, Info: %s\n", workerId, err)
-			}
-			return
+		}
+		resultsChan <- task.Execute(ctx)
+	}
+}
+
+// cancelUpdates cancels the updates in progress of the running stacks, the run being canceled they get their own context
+func cancelUpdates(graph *config.Graph, inFlight map[string]bool, cm cfn.CFNManager, log logger.Logger) {
+	for name := range inFlight {
+		stack := graph.Nodes[name].Stack
+		ctx := context.WithValue(logger.WithLogger(context.Background(), log), logger.StackKey, name)
+		if canceled, err := stack.CancelUpdate(ctx, cm); err != nil {
+			log.ErrorCtxf(ctx, "Failed to cancel the update: %s\n", err)
+		} else if canceled {
+			log.WarnCtxf(ctx, "Update canceled, CloudFormation rolls the stack back.\n")
 		}
 	}
 }
@@ -214,6 +621,33 @@ func (c *Composer) PrintConfig() {
 	if c.CherryPickedFlow != "" {
 		fmt.Printf("Selected Flow: %s\n", c.CherryPickedFlow)
 	}
+	if c.Concurrency > 0 {
+		fmt.Printf("Concurrency: %d\n", c.Concurrency)
+	}
+	if c.PlanOut != "" {
+		fmt.Printf("PlanOut: %s\n", c.PlanOut)
+	}
+	if c.PlanFile != "" {
+		fmt.Printf("PlanFile: %s\n", c.PlanFile)
+	}
+	if c.ChangeSetMode {
+		fmt.Printf("ChangeSetMode: %t, AutoApprove: %t\n", c.ChangeSetMode, c.AutoApprove)
+	}
+	if c.Timeout > 0 {
+		fmt.Printf("Timeout: %s\n", c.Timeout)
+	}
+	if c.CancelUpdates {
+		fmt.Printf("CancelUpdates: %t\n", c.CancelUpdates)
+	}
+	if c.ContinueOnError {
+		fmt.Printf("ContinueOnError: %t\n", c.ContinueOnError)
+	}
+	if c.LogDir != "" {
+		fmt.Printf("LogDir: %s\n", c.LogDir)
+	}
+	if c.UI {
+		fmt.Printf("UI: %t\n", c.UI)
+	}
 	fmt.Printf("DryRun: %t\n", c.DryRun)
 	fmt.Printf("LogLevel: %s\n", c.LogLevel)
 	fmt.Printf("DeployMode: %t\n\n", c.DeployMode)
@@ -0,0 +1,216 @@
+package compose
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// dashboardRefresh is the delay between two renders of the dashboard, for the elapsed times
+const dashboardRefresh = time.Second
+
+// maxEventWidth truncates the last resource event of the stacks so that a row fits on a line
+const maxEventWidth = 60
+
+// dashboardRow is the live state of a stack
+type dashboardRow struct {
+	StackSummary
+	Status    string
+	LastEvent string
+	Started   time.Time
+}
+
+/*
+dashboard is a Sink rendering a live view of the run on a terminal: a row per stack in the order of the run, so grouped by order and flow,
+with its CloudFormation status, elapsed time and latest resource event, under the overall progress.
+Every render moves the cursor back over the previous one, so nothing else should write to the terminal meanwhile.
+*/
+type dashboard struct {
+	mu      sync.Mutex
+	out     io.Writer
+	mode    string
+	started time.Time
+	rows    []*dashboardRow
+	index   map[string]*dashboardRow
+	lines   int
+	done    chan struct{}
+	stopped bool
+}
+
+func newDashboard(out io.Writer) *dashboard {
+	return &dashboard{out: out, index: make(map[string]*dashboardRow), done: make(chan struct{})}
+}
+
+func (d *dashboard) Send(e Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.stopped {
+		return nil
+	}
+
+	row := d.index[e.StackName]
+	switch e.Type {
+	case EventRunStarted:
+		d.mode, d.started = e.Mode, e.Time
+		for _, s := range e.Stacks {
+			r := &dashboardRow{StackSummary: s, Status: "PENDING"}
+			d.rows = append(d.rows, r)
+			d.index[s.StackName] = r
+		}
+		go d.refresh()
+	case EventStackStarted:
+		if row != nil {
+			row.Status, row.Started = "STARTED", e.Time
+		}
+	case EventStackStatus:
+		if row != nil {
+			row.Status = e.Status
+		}
+	case EventStackResource:
+		if row != nil {
+			row.LastEvent = e.LogicalResourceID + " (" + e.ResourceType + ") " + e.Status
+		}
+	case EventStackFinished:
+		if row != nil {
+			row.Outcome, row.Duration = e.Outcome, e.Duration
+			if row.Status == "PENDING" || row.Status == "STARTED" {
+				row.Status = "-"
+			}
+		}
+	case EventRunFinished:
+		d.render(e.Time)
+		d.stop()
+		return nil
+	}
+
+	d.render(e.Time)
+	return nil
+}
+
+// Stop ends the refresh of the dashboard and ignores the next events, the run stops it even if run_finished wasn't received
+func (d *dashboard) Stop() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stop()
+}
+
+func (d *dashboard) stop() {
+	if !d.stopped {
+		d.stopped = true
+		close(d.done)
+	}
+}
+
+// refresh renders the dashboard every second for the elapsed times, until the dashboard is stopped
+func (d *dashboard) refresh() {
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			d.mu.Lock()
+			if !d.stopped {
+				d.render(now)
+			}
+			d.mu.Unlock()
+		}
+	}
+}
+
+// render draws the dashboard over the previous render
+func (d *dashboard) render(now time.Time) {
+	var buf bytes.Buffer
+	if d.lines > 0 {
+		fmt.Fprintf(&buf, "\033[%dA\033[J", d.lines)
+	}
+
+	finished, failed := 0, 0
+	for _, row := range d.rows {
+		if row.Outcome != "" {
+			finished++
+		}
+		if row.Outcome == OutcomeFailed || row.Outcome == OutcomeTimedOut || row.Outcome == OutcomeInterrupted {
+			failed++
+		}
+	}
+
+	var frame bytes.Buffer
+	fmt.Fprintf(&frame, "cfn-compose %s  %s %d/%d stacks", d.mode, progressBar(finished, len(d.rows)), finished, len(d.rows))
+	if failed > 0 {
+		fmt.Fprintf(&frame, ", %d failed", failed)
+	}
+	fmt.Fprintf(&frame, "  elapsed: %s\n\n", now.Sub(d.started).Round(time.Second))
+
+	tw := tabwriter.NewWriter(&frame, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ORDER\tFLOW\tSTACK\tSTATUS\tRESULT\tELAPSED\tLAST EVENT")
+	for _, row := range d.rows {
+		elapsed := "-"
+		switch {
+		case row.Outcome != "" && !row.Started.IsZero():
+			elapsed = row.Duration.Round(time.Second).String()
+		case !row.Started.IsZero():
+			elapsed = now.Sub(row.Started).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", row.Order, row.FlowName, row.StackName, row.Status, orDash(row.Outcome), elapsed, orDash(truncate(row.LastEvent, maxEventWidth)))
+	}
+	tw.Flush()
+
+	d.lines = strings.Count(frame.String(), "\n")
+	buf.Write(frame.Bytes())
+	d.out.Write(buf.Bytes())
+}
+
+func progressBar(done, total int) string {
+	const width = 20
+	filled := 0
+	if total > 0 {
+		filled = done * width / total
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+func truncate(s string, width int) string {
+	if len(s) <= width {
+		return s
+	}
+	return s[:width-3] + "..."
+}
+
+// heldWriter holds the writes until released so that the logs don't break the dashboard, then writes through
+type heldWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	buf      bytes.Buffer
+	released bool
+}
+
+func (h *heldWriter) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.released {
+		return h.w.Write(p)
+	}
+	return h.buf.Write(p)
+}
+
+// release writes the held logs and lets the next ones through
+func (h *heldWriter) release() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.released = true
+	h.w.Write(h.buf.Bytes())
+	h.buf.Reset()
+}
@@ -0,0 +1,104 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+func TestDashboard(t *testing.T) {
+	t.Log("When the events of a run are rendered")
+	{
+		var out bytes.Buffer
+		d := newDashboard(&out)
+		start := time.Now()
+		d.Send(Event{Type: EventRunStarted, Time: start, Mode: "deploy", Stacks: []StackSummary{
+			{FlowName: "Network", Order: 0, StackName: "vpc"},
+			{FlowName: "App", Order: 1, StackName: "api"},
+		}})
+		d.Send(Event{Type: EventStackStarted, Time: start, FlowName: "Network", StackName: "vpc"})
+		d.Send(Event{Type: EventStackStatus, Time: start.Add(time.Second), FlowName: "Network", StackName: "vpc", Status: "CREATE_IN_PROGRESS"})
+		d.Send(Event{Type: EventStackResource, Time: start.Add(2 * time.Second), FlowName: "Network", StackName: "vpc", LogicalResourceID: "VPC", ResourceType: "AWS::EC2::VPC", Status: "CREATE_COMPLETE"})
+
+		out.Reset()
+		d.Send(Event{Type: EventStackFinished, Time: start.Add(3 * time.Second), FlowName: "Network", StackName: "vpc", Outcome: OutcomeCreated, Duration: 3 * time.Second})
+		frame := out.String()
+		if !strings.HasPrefix(frame, "\033[") {
+			t.Fatalf("Expected the frame to be drawn over the previous one but got:\n%q", frame)
+		}
+		lines := strings.Split(frame, "\n")
+		if !strings.Contains(lines[0], "deploy") || !strings.Contains(lines[0], "1/2 stacks") || !strings.Contains(lines[0], "elapsed: 3s") {
+			t.Fatalf("Expected the progress of the run in the header but got %q", lines[0])
+		}
+		if strings.Index(frame, "vpc") > strings.Index(frame, "api") {
+			t.Fatalf("Expected the stacks in the order of the run but got:\n%s", frame)
+		}
+		for _, expected := range []string{"CREATE_IN_PROGRESS", "created", "VPC (AWS::EC2::VPC) CREATE_COMPLETE", "PENDING"} {
+			if !strings.Contains(frame, expected) {
+				t.Fatalf("Expected the frame to contain %q but got:\n%s", expected, frame)
+			}
+		}
+
+		d.Send(Event{Type: EventRunFinished, Time: start.Add(4 * time.Second)})
+		out.Reset()
+		d.Send(Event{Type: EventStackStatus, StackName: "api", Status: "CREATE_IN_PROGRESS"})
+		if out.Len() != 0 {
+			t.Fatalf("Expected nothing to be rendered once the run is finished but got:\n%s", out.String())
+		}
+	}
+
+	t.Log("When the dashboard is stopped without run_finished")
+	{
+		var out bytes.Buffer
+		d := newDashboard(&out)
+		d.Send(Event{Type: EventRunStarted, Time: time.Now(), Mode: "deploy", Stacks: []StackSummary{{FlowName: "Network", StackName: "vpc"}}})
+
+		d.Stop()
+		d.Stop()
+		select {
+		case <-d.done:
+		default:
+			t.Fatal("Expected the refresh of the dashboard to be stopped")
+		}
+		out.Reset()
+		d.Send(Event{Type: EventStackStatus, StackName: "vpc", Status: "CREATE_IN_PROGRESS"})
+		if out.Len() != 0 {
+			t.Fatalf("Expected nothing to be rendered once the dashboard is stopped but got:\n%s", out.String())
+		}
+	}
+
+	t.Log("When a run is deployed with the dashboard")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		var logs, out bytes.Buffer
+		l := logger.New(&logs, &logs, logger.INFO)
+		c := Composer{ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake, Logger: &l, Output: &out, UI: true}
+		if _, err := c.Deploy(context.Background()); err != nil {
+			t.Fatalf("Deploy should return nil but found error: %s", err)
+		}
+
+		if logs.Len() != 0 {
+			t.Fatalf("Expected the logs to be replaced by the dashboard but got:\n%s", logs.String())
+		}
+		for _, expected := range []string{"ORDER", "test-sg", "test-ec2", "test-alarm", "3/3 stacks"} {
+			if !strings.Contains(out.String(), expected) {
+				t.Fatalf("Expected the dashboard to contain %q but got:\n%s", expected, out.String())
+			}
+		}
+	}
+
+	t.Log("When the change sets should be prompted for")
+	{
+		l := logger.New(io.Discard, io.Discard, logger.ERROR)
+		c := Composer{ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: cfntest.NewFakeCloudFormation(), Logger: &l, Output: io.Discard, UI: true, DeployMode: true, ChangeSetMode: true}
+		if err := c.Apply(); ExitCode(err) != ExitConfig {
+			t.Fatalf("Expected a config error but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Exit codes of the compose commands
+const (
+	ExitOK = 0
+	// ExitError is an unexpected error, e.g. the plan file couldn't be written
+	ExitError = 1
+	// ExitConfig is an invalid compose file, flag combination or plan file
+	ExitConfig = 2
+	// ExitAuth is a missing, expired or rejected AWS credential
+	ExitAuth = 3
+	// ExitStackFailed is a stack failure or timeout that stopped the run
+	ExitStackFailed = 4
+	// ExitPartial is a run continued on error where some stacks failed while the others completed
+	ExitPartial = 5
+	// ExitInterrupted is a run stopped by SIGINT or SIGTERM
+	ExitInterrupted = 130
+)
+
+// authErrors are the AWS error codes of the requests rejected because of the credentials
+var authErrors = []string{
+	"NoCredentialProviders",
+	"ExpiredToken",
+	"ExpiredTokenException",
+	"InvalidClientTokenId",
+	"UnrecognizedClientException",
+	"SignatureDoesNotMatch",
+	"AccessDenied",
+	"AccessDeniedException",
+	"SharedConfigProfileNotExistsError",
+}
+
+/*
+RunError is returned by ApplyContext when the run didn't complete.
+Code is the exit code of the command, Summary holds the outcome of every stack when the run started.
+*/
+type RunError struct {
+	Code    int
+	Summary *Summary
+	Err     error
+}
+
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// configError reports an invalid configuration of the run
+func configError(format string, a ...interface{}) error {
+	return &RunError{Code: ExitConfig, Err: fmt.Errorf(format, a...)}
+}
+
+// ExitCode returns the exit code of the command for the error returned by Apply
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var rerr *RunError
+	if errors.As(err, &rerr) {
+		return rerr.Code
+	}
+	if isAuthError(err) {
+		return ExitAuth
+	}
+	return ExitError
+}
+
+// isAuthError reports whether the AWS request failed because of the credentials
+func isAuthError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	for _, code := range authErrors {
+		if aerr.Code() == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package compose
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestExitCode(t *testing.T) {
+	t.Log("When the errors are classified")
+	{
+		tests := []struct {
+			err      error
+			expected int
+		}{
+			{nil, ExitOK},
+			{errors.New("failed to write the plan"), ExitError},
+			{configError("Cannot find the selected flow: %s in the config", "App"), ExitConfig},
+			{fmt.Errorf("[FLOW: App] [STACK: api]. Error: %w", awserr.New("ExpiredToken", "The security token included in the request is expired", nil)), ExitAuth},
+			{awserr.New("ValidationError", "Template format error", nil), ExitError},
+			{&RunError{Code: ExitPartial, Err: errors.New("compose failed: 1 created, 1 failed")}, ExitPartial},
+		}
+
+		for _, test := range tests {
+			if code := ExitCode(test.err); code != test.expected {
+				t.Fatalf("Expected exit code %d for %v but got %d", test.expected, test.err, code)
+			}
+		}
+	}
+
+	t.Log("When a stack failed because of the credentials")
+	{
+		s := Summary{DeployMode: true}
+		s.Add(Result{StackName: "api", Error: fmt.Errorf("Failed while checking stack status, ERROR %w", awserr.New("InvalidClientTokenId", "The security token included in the request is invalid", nil))}, false)
+		s.Add(Result{StackName: "db", Error: fmt.Errorf("Skipping... %w", errNotStarted)}, true)
+
+		if !s.AuthFailed() || s.Completed() != 0 {
+			t.Fatalf("Expected the summary to report the credentials failure but got %+v", s.Stacks)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package compose
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/rbalman/cfn-compose/cfn"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+// Event types
+const (
+	// EventRunStarted is emitted once the compose config is loaded, before the first stack starts, with the stacks of the run
+	EventRunStarted = "run_started"
+	// EventOrderStarted is emitted when the first stack of an order starts
+	EventOrderStarted = "order_started"
+	// EventFlowStarted is emitted when the first stack of a flow starts
+	EventFlowStarted = "flow_started"
+	// EventStackStarted is emitted when the task of a stack starts, it isn't emitted for the stacks skipped once the run stopped
+	EventStackStarted = "stack_started"
+	// EventStackStatus is emitted when the status of a stack changes while its operation is waited for, see cfn.StackEventType
+	EventStackStatus = "stack_status"
+	// EventStackResource is emitted for every resource event of a stack while its operation is waited for, see cfn.StackEventType
+	EventStackResource = "stack_resource"
+	// EventStackFinished is emitted once a stack is over, whatever its outcome
+	EventStackFinished = "stack_finished"
+	// EventRunFinished is emitted once all the stacks are over, before the summary is rendered
+	EventRunFinished = "run_finished"
+)
+
+// Event is a step of a compose run, given to the sinks of the EventBus
+type Event struct {
+	Type      string
+	Time      time.Time
+	FlowName  string
+	Order     int
+	StackName string
+	// Mode is deploy or destroy, set on run_started
+	Mode   string
+	DryRun bool
+	// Status is the stack status of stack_status, or the resource status of stack_resource
+	Status string
+	// LogicalResourceID, ResourceType and Reason describe the resource of stack_resource
+	LogicalResourceID string
+	ResourceType      string
+	Reason            string
+	// Outcome is the outcome of the finished stack, see the Outcome* constants
+	Outcome  string
+	Duration time.Duration
+	Error    error
+	// Stacks lists the stacks of run_started in the order they run, without outcome
+	Stacks []StackSummary
+	// Summary is set once the run is finished
+	Summary *Summary
+}
+
+// jsonStack is a stack of run_started or of the summary of run_finished
+type jsonStack struct {
+	FlowName  string   `json:"flow"`
+	Order     int      `json:"order"`
+	StackName string   `json:"stack"`
+	Outcome   string   `json:"outcome,omitempty"`
+	Duration  *float64 `json:"duration_seconds,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	// RootCause is the failed resource of a failed stack, when it is known
+	RootCause *jsonRootCause `json:"root_cause,omitempty"`
+}
+
+// jsonRootCause is the failed resource of a cfn.StackError
+type jsonRootCause struct {
+	Status            string   `json:"status,omitempty"`
+	LogicalResourceID string   `json:"logical_resource_id"`
+	ResourceType      string   `json:"resource_type"`
+	ResourceStatus    string   `json:"resource_status"`
+	Reason            string   `json:"reason,omitempty"`
+	NestedStacks      []string `json:"nested_stacks,omitempty"`
+}
+
+// MarshalJSON writes the event with snake case keys, the errors as strings and the durations in seconds
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := struct {
+		Type              string      `json:"type"`
+		Time              time.Time   `json:"time"`
+		FlowName          string      `json:"flow,omitempty"`
+		Order             *int        `json:"order,omitempty"`
+		StackName         string      `json:"stack,omitempty"`
+		Mode              string      `json:"mode,omitempty"`
+		DryRun            bool        `json:"dry_run,omitempty"`
+		Status            string      `json:"status,omitempty"`
+		LogicalResourceID string      `json:"logical_resource_id,omitempty"`
+		ResourceType      string      `json:"resource_type,omitempty"`
+		Reason            string      `json:"reason,omitempty"`
+		Outcome           string      `json:"outcome,omitempty"`
+		Duration          float64     `json:"duration_seconds,omitempty"`
+		Error             string      `json:"error,omitempty"`
+		Counts            string      `json:"counts,omitempty"`
+		Stacks            []jsonStack `json:"stacks,omitempty"`
+	}{
+		Type: e.Type, Time: e.Time, FlowName: e.FlowName, StackName: e.StackName, Mode: e.Mode, DryRun: e.DryRun,
+		Status: e.Status, LogicalResourceID: e.LogicalResourceID, ResourceType: e.ResourceType, Reason: e.Reason,
+		Outcome: e.Outcome, Duration: e.Duration.Seconds(),
+	}
+
+	// the order is only meaningful for the events of an order, a flow or a stack
+	if e.FlowName != "" || e.Type == EventOrderStarted {
+		j.Order = &e.Order
+	}
+	if e.Error != nil {
+		j.Error = strings.TrimSpace(e.Error.Error())
+	}
+	stacks := e.Stacks
+	if e.Summary != nil {
+		j.Counts = e.Summary.Counts()
+		stacks = e.Summary.Stacks
+	}
+	for _, s := range stacks {
+		stack := jsonStack{FlowName: s.FlowName, Order: s.Order, StackName: s.StackName, Outcome: s.Outcome}
+		// the stacks of run_started have no outcome yet
+		if e.Summary != nil {
+			duration := s.Duration.Seconds()
+			stack.Duration = &duration
+		}
+		if s.Error != nil {
+			stack.Error = strings.TrimSpace(s.Error.Error())
+		}
+		if serr := rootCause(s.Error); serr != nil {
+			stack.RootCause = &jsonRootCause{Status: serr.Status, LogicalResourceID: serr.LogicalResourceID, ResourceType: serr.ResourceType, ResourceStatus: serr.ResourceStatus, Reason: serr.Reason, NestedStacks: serr.NestedStacks}
+		}
+		j.Stacks = append(j.Stacks, stack)
+	}
+
+	return json.Marshal(j)
+}
+
+// Sink receives the events of a run, e.g. to notify a chat or feed a dashboard
+type Sink interface {
+	Send(Event) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(Event) error
+
+func (f SinkFunc) Send(e Event) error {
+	return f(e)
+}
+
+// sinkBufferSize is the number of events a sink can lag behind before the next ones are dropped
+const sinkBufferSize = 1024
+
+/*
+EventBus hands the events of a run to its sinks in the order they are emitted. Every sink gets the events on its own
+goroutine so that a slow sink, e.g. a webhook, doesn't slow the deployment down: the events a sink can't keep up with
+are dropped with a warning, except run_started and run_finished which wait for room in the queue of the sink.
+The stacks run concurrently so Emit is safe for concurrent use. A failing sink doesn't stop the run, its error is logged.
+Close delivers the pending events.
+*/
+type EventBus struct {
+	mu     sync.Mutex
+	queues []chan Event
+	wg     sync.WaitGroup
+	closed bool
+	log    logger.Logger
+}
+
+func NewEventBus(log logger.Logger, sinks ...Sink) *EventBus {
+	b := &EventBus{log: log}
+	for _, sink := range sinks {
+		q := make(chan Event, sinkBufferSize)
+		b.queues = append(b.queues, q)
+		b.wg.Add(1)
+		go b.deliver(sink, q)
+	}
+	return b
+}
+
+// deliver sends the queued events to the sink until the bus is closed
+func (b *EventBus) deliver(sink Sink, q chan Event) {
+	defer b.wg.Done()
+	for e := range q {
+		if err := sink.Send(e); err != nil {
+			b.log.Warnf("Failed while sending the %s event: %s\n", e.Type, err)
+		}
+	}
+}
+
+// Emit timestamps the event and queues it for every sink, the events emitted once the bus is closed are ignored
+func (b *EventBus) Emit(e Event) {
+	if b == nil || len(b.queues) == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	e.Time = time.Now()
+	for _, q := range b.queues {
+		// the sinks rely on the run events to start and end, they are never dropped
+		if e.Type == EventRunStarted || e.Type == EventRunFinished {
+			q <- e
+			continue
+		}
+		select {
+		case q <- e:
+		default:
+			b.log.Warnf("Dropping... the %s event as a sink is %d events behind\n", e.Type, sinkBufferSize)
+		}
+	}
+}
+
+// Close waits for the sinks to receive the queued events, it can be called more than once
+func (b *EventBus) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, q := range b.queues {
+			close(q)
+		}
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
+
+func stackFinishedEvent(s StackSummary) Event {
+	return Event{Type: EventStackFinished, FlowName: s.FlowName, Order: s.Order, StackName: s.StackName, Outcome: s.Outcome, Duration: s.Duration, Error: s.Error}
+}
+
+/*
+stackEvent turns a stack event seen by the cfn waiters into a stack_status event when it is about the stack itself,
+a stack_resource event otherwise. The flow, order and stack come from the context of the task.
+*/
+func stackEvent(ctx context.Context, se *cloudformation.StackEvent) Event {
+	flow, _ := ctx.Value(logger.FlowKey).(string)
+	order, _ := ctx.Value(logger.OrderKey).(int)
+	stack, _ := ctx.Value(logger.StackKey).(string)
+
+	e := Event{Type: cfn.StackEventType(se), FlowName: flow, Order: order, StackName: stack, Status: aws.StringValue(se.ResourceStatus), Reason: aws.StringValue(se.ResourceStatusReason)}
+	if e.Type == EventStackStatus {
+		return e
+	}
+	e.LogicalResourceID = aws.StringValue(se.LogicalResourceId)
+	e.ResourceType = aws.StringValue(se.ResourceType)
+	return e
+}
@@ -0,0 +1,190 @@
+package compose
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn/cfntest"
+	"github.com/rbalman/cfn-compose/logger"
+)
+
+func TestEvents(t *testing.T) {
+	ctx := context.Background()
+	l := logger.New(io.Discard, io.Discard, logger.ERROR)
+
+	t.Log("When the events of a run are written as JSON lines")
+	{
+		fake := cfntest.NewFakeCloudFormation()
+		fake.Fail("test-ec2", "Instance", "Invalid AMI")
+		var out bytes.Buffer
+		c := Composer{ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: fake, Logger: &l, Output: io.Discard, Sinks: []Sink{NewJSONLinesSink(&out)}}
+		c.Deploy(ctx)
+
+		var types []string
+		var failed, finished map[string]interface{}
+		scanner := bufio.NewScanner(&out)
+		for scanner.Scan() {
+			var e map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("Expected a JSON object per line but got %s: %s", scanner.Text(), err)
+			}
+			types = append(types, e["type"].(string))
+			if e["type"] == EventStackResource && e["status"] == "CREATE_FAILED" {
+				failed = e
+			}
+			if e["type"] == EventRunFinished {
+				finished = e
+			}
+		}
+
+		expected := []string{EventRunStarted, EventOrderStarted, EventFlowStarted, EventStackStarted, EventStackStatus, EventStackStatus, EventStackFinished}
+		if !reflect.DeepEqual(types[:len(expected)], expected) || types[len(types)-1] != EventRunFinished {
+			t.Fatalf("Expected the events to start with %v and end with %s but got %v", expected, EventRunFinished, types)
+		}
+		if failed == nil || failed["stack"] != "test-ec2" || failed["flow"] != "App" || failed["logical_resource_id"] != "Instance" || failed["reason"] != "Invalid AMI" {
+			t.Fatalf("Expected the failed resource event of test-ec2 but got %v", failed)
+		}
+		var rootCause interface{}
+		for _, stack := range finished["stacks"].([]interface{}) {
+			if s := stack.(map[string]interface{}); s["stack"] == "test-ec2" {
+				rootCause = s["root_cause"]
+			}
+		}
+		if rc, ok := rootCause.(map[string]interface{}); !ok || rc["logical_resource_id"] != "Instance" || rc["resource_type"] == "" || rc["reason"] != "Invalid AMI" {
+			t.Fatalf("Expected the root cause of test-ec2 in the summary but got %v", rootCause)
+		}
+	}
+
+	t.Log("When the events are posted to a webhook")
+	{
+		var mu sync.Mutex
+		var received []Event
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var e struct {
+				Type    string `json:"type"`
+				Stack   string `json:"stack"`
+				Outcome string `json:"outcome"`
+				Counts  string `json:"counts"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&e); err != nil || r.Header.Get("Content-Type") != "application/json" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			received = append(received, Event{Type: e.Type, StackName: e.Stack, Outcome: e.Outcome, Reason: e.Counts})
+			mu.Unlock()
+		}))
+		defer server.Close()
+
+		c := Composer{ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: cfntest.NewFakeCloudFormation(), Logger: &l, Output: io.Discard, Sinks: []Sink{WebhookSink{URL: server.URL}}}
+		if _, err := c.Deploy(ctx); err != nil {
+			t.Fatalf("Deploy should return nil but found error: %s", err)
+		}
+
+		last := received[len(received)-1]
+		if last.Type != EventRunFinished || last.Reason != "3 created" {
+			t.Fatalf("Expected the run_finished event to be posted last but got %+v", last)
+		}
+	}
+
+	t.Log("When the webhook rejects the events")
+	{
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		if err := (WebhookSink{URL: server.URL}).Send(Event{Type: EventRunStarted}); err == nil || !strings.Contains(err.Error(), "500") {
+			t.Fatalf("Expected the webhook error to be returned but got %v", err)
+		}
+
+		c := Composer{ConfigFile: writeComposeFile(t, testComposeFile), CFNClient: cfntest.NewFakeCloudFormation(), Logger: &l, Output: io.Discard, Sinks: []Sink{WebhookSink{URL: server.URL}}}
+		if _, err := c.Deploy(ctx); err != nil {
+			t.Fatalf("Expected a failing sink not to fail the run but found error: %s", err)
+		}
+	}
+
+	t.Log("When a sink is slower than the run")
+	{
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var slow []string
+		var logs bytes.Buffer
+		bus := NewEventBus(logger.New(&logs, &logs, logger.INFO), SinkFunc(func(e Event) error {
+			<-release
+			mu.Lock()
+			slow = append(slow, e.StackName)
+			mu.Unlock()
+			return nil
+		}))
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < sinkBufferSize+2; i++ {
+				bus.Emit(Event{Type: EventStackStatus, StackName: fmt.Sprint(i)})
+			}
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected Emit not to wait for the slow sink")
+		}
+		if !strings.Contains(logs.String(), "Dropping... the stack_status event") {
+			t.Fatalf("Expected the events the sink can't keep up with to be dropped but got:\n%s", logs.String())
+		}
+
+		finished := make(chan struct{})
+		go func() {
+			bus.Emit(Event{Type: EventRunFinished, StackName: "finished"})
+			close(finished)
+		}()
+		close(release)
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected run_finished to be queued once the slow sink caught up")
+		}
+		bus.Close()
+		bus.Emit(Event{Type: EventRunFinished})
+		if len(slow) < sinkBufferSize+1 || slow[len(slow)-1] != "finished" {
+			t.Fatalf("Expected the queued events and run_finished to be delivered once closed but got %d events", len(slow))
+		}
+		for i, stack := range slow[:len(slow)-1] {
+			if stack != fmt.Sprint(i) {
+				t.Fatalf("Expected the events to be delivered in order but got %s at %d", stack, i)
+			}
+		}
+	}
+
+	t.Log("When the events are piped to a command")
+	{
+		path := filepath.Join(t.TempDir(), "events")
+		sink := CommandSink{Command: `echo "$CFNC_EVENT_TYPE $(cat)" >> ` + path}
+		if err := sink.Send(Event{Type: EventStackFinished, StackName: "api", Outcome: OutcomeCreated}); err != nil {
+			t.Fatalf("Send should return nil but found error: %s", err)
+		}
+
+		data, _ := os.ReadFile(path)
+		if !strings.HasPrefix(string(data), EventStackFinished+" {") || !strings.Contains(string(data), `"outcome":"created"`) {
+			t.Fatalf("Expected the event to be given to the command but got %s", data)
+		}
+
+		if err := (CommandSink{Command: "exit 3"}).Send(Event{Type: EventRunStarted}); err == nil {
+			t.Fatal("Send should return error but found nil")
+		}
+	}
+}
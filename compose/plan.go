@@ -0,0 +1,264 @@
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn"
+)
+
+// PlannedStack is the dry run plan of a stack along with its place in the compose file
+type PlannedStack struct {
+	FlowName string `json:"flow"`
+	Order    int    `json:"order"`
+	cfn.StackPlan
+}
+
+// planVersion is the version of the plan file format
+const planVersion = 1
+
+// Plan aggregates the dry run plans of every stack of the compose run
+type Plan struct {
+	Version int `json:"version"`
+	// Mode is either deploy or destroy
+	Mode       string         `json:"mode"`
+	ConfigFile string         `json:"config_file,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Summary    PlanSummary    `json:"summary"`
+	Stacks     []PlannedStack `json:"stacks"`
+}
+
+// PlanSummary counts the planned stack actions and resource changes
+type PlanSummary struct {
+	Create       int `json:"create"`
+	Update       int `json:"update"`
+	Delete       int `json:"delete"`
+	Skip         int `json:"skip"`
+	Blocked      int `json:"blocked"`
+	Add          int `json:"add"`
+	Modify       int `json:"modify"`
+	Remove       int `json:"remove"`
+	Replacements int `json:"replacements"`
+}
+
+func (p *Plan) Add(r Result) {
+	p.Stacks = append(p.Stacks, PlannedStack{FlowName: r.FlowName, Order: r.Order, StackPlan: *r.Plan})
+}
+
+// Sort orders the stacks by order, flow and stack name
+func (p *Plan) Sort() {
+	sort.SliceStable(p.Stacks, func(i, j int) bool {
+		a, b := p.Stacks[i], p.Stacks[j]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		if a.FlowName != b.FlowName {
+			return a.FlowName < b.FlowName
+		}
+		return a.StackName < b.StackName
+	})
+}
+
+// Summarize counts the planned actions of the stacks
+func (p Plan) Summarize() PlanSummary {
+	var s PlanSummary
+	for _, stack := range p.Stacks {
+		switch stack.Action {
+		case cfn.PlanCreate:
+			s.Create++
+		case cfn.PlanUpdate:
+			s.Update++
+		case cfn.PlanDelete:
+			s.Delete++
+		case cfn.PlanBlocked:
+			s.Blocked++
+		default:
+			s.Skip++
+		}
+
+		for _, c := range stack.Changes {
+			switch c.Action {
+			case "Add":
+				s.Add++
+			case "Remove":
+				s.Remove++
+			default:
+				s.Modify++
+			}
+		}
+		s.Replacements += stack.Replacements()
+	}
+	return s
+}
+
+// Render writes the plan as a table of resource changes per stack, grouped by order and flow, followed by the summary
+func (p Plan) Render(w io.Writer) {
+	p.Sort()
+
+	fmt.Fprintf(w, "\nPLAN\n")
+	for i, stack := range p.Stacks {
+		if i == 0 || stack.Order != p.Stacks[i-1].Order {
+			fmt.Fprintf(w, "ORDER: %d\n", stack.Order)
+		}
+		if i == 0 || stack.Order != p.Stacks[i-1].Order || stack.FlowName != p.Stacks[i-1].FlowName {
+			fmt.Fprintf(w, "  FLOW: %s\n", stack.FlowName)
+		}
+
+		renderStack(w, stack.StackPlan)
+	}
+
+	s := p.Summarize()
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to delete, %d unchanged, %d blocked.\n", s.Create, s.Update, s.Delete, s.Skip, s.Blocked)
+	fmt.Fprintf(w, "Resources: %d to add, %d to modify, %d to remove, %d replacement(s).\n\n", s.Add, s.Modify, s.Remove, s.Replacements)
+}
+
+// renderStack writes the planned action of the stack followed by the table of its resource changes
+func renderStack(w io.Writer, stack cfn.StackPlan) {
+	fmt.Fprintf(w, "    %s %s (%s) %s", actionSymbol(stack.Action), stack.StackName, stack.Status, actionText(stack.Action))
+	if stack.Reason != "" {
+		fmt.Fprintf(w, ": %s", stack.Reason)
+	}
+	fmt.Fprintln(w)
+	if len(stack.Changes) == 0 {
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "        \tACTION\tLOGICAL ID\tPHYSICAL ID\tTYPE\tREPLACEMENT\tSCOPE")
+	for _, c := range stack.Changes {
+		fmt.Fprintf(tw, "        \t%s %s\t%s\t%s\t%s\t%s\t%s\n", changeSymbol(c), c.Action, c.LogicalResourceID, orDash(c.PhysicalResourceID), c.ResourceType, orDash(c.Replacement), orDash(strings.Join(c.Scope, ",")))
+	}
+	tw.Flush()
+}
+
+// WriteFile writes the plan as JSON, with its summary
+func (p Plan) WriteFile(path string) error {
+	p.Sort()
+	p.Version = planVersion
+	p.Summary = p.Summarize()
+	if p.Stacks == nil {
+		p.Stacks = []PlannedStack{}
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// ReadPlanFile reads a plan written by WriteFile
+func ReadPlanFile(path string) (Plan, error) {
+	var p Plan
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, err
+	}
+
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("invalid plan file %s: %w", path, err)
+	}
+
+	if p.Version != planVersion {
+		return p, fmt.Errorf("unsupported plan version %d, expected %d", p.Version, planVersion)
+	}
+	return p, nil
+}
+
+/*
+Validate checks that the plan can be applied to the given stacks: it must be a deploy plan without
+blocked stacks, covering exactly the same stacks.
+*/
+func (p Plan) Validate(stackNames []string) error {
+	if p.Mode != "deploy" {
+		return fmt.Errorf("only deploy plans can be applied, found %s plan", p.Mode)
+	}
+
+	planned := make(map[string]bool)
+	for _, stack := range p.Stacks {
+		if stack.Action == cfn.PlanBlocked {
+			return fmt.Errorf("stack %s is blocked in the plan: %s", stack.StackName, stack.Reason)
+		}
+		planned[stack.StackName] = true
+	}
+
+	for _, name := range stackNames {
+		if !planned[name] {
+			return fmt.Errorf("stack %s is not part of the plan", name)
+		}
+		delete(planned, name)
+	}
+	for name := range planned {
+		return fmt.Errorf("planned stack %s is not part of the compose configuration", name)
+	}
+
+	return nil
+}
+
+// stack returns the plan of the given stack
+func (p Plan) stack(name string) *cfn.StackPlan {
+	for i := range p.Stacks {
+		if p.Stacks[i].StackName == name {
+			return &p.Stacks[i].StackPlan
+		}
+	}
+	return nil
+}
+
+func actionSymbol(action string) string {
+	switch action {
+	case cfn.PlanCreate:
+		return "+"
+	case cfn.PlanUpdate:
+		return "~"
+	case cfn.PlanDelete:
+		return "-"
+	case cfn.PlanBlocked:
+		return "!"
+	default:
+		return "="
+	}
+}
+
+func actionText(action string) string {
+	switch action {
+	case cfn.PlanCreate:
+		return "will be created"
+	case cfn.PlanUpdate:
+		return "will be updated"
+	case cfn.PlanDelete:
+		return "will be deleted"
+	case cfn.PlanBlocked:
+		return "is blocked"
+	default:
+		return "no changes"
+	}
+}
+
+func changeSymbol(c cfn.ResourceChange) string {
+	switch {
+	case c.Action == "Add":
+		return "+"
+	case c.Action == "Remove":
+		return "-"
+	case c.Replacement == "True":
+		return "-/+"
+	case c.Replacement == "Conditional":
+		return "~/+"
+	default:
+		return "~"
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,57 @@
+package compose
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/rbalman/cfn-compose/cfn"
+)
+
+func TestPlan(t *testing.T) {
+	var plan Plan
+	plan.Add(Result{FlowName: "app", Order: 1, StackName: "api", Plan: &cfn.StackPlan{StackName: "api", Status: "UPDATE_COMPLETE", Action: cfn.PlanUpdate, Changes: []cfn.ResourceChange{
+		{Action: "Modify", LogicalResourceID: "Queue", PhysicalResourceID: "queue-url", ResourceType: "AWS::SQS::Queue", Replacement: "True", Scope: []string{"Properties"}},
+		{Action: "Add", LogicalResourceID: "Topic", ResourceType: "AWS::SNS::Topic"},
+	}}})
+	plan.Add(Result{FlowName: "network", Order: 0, StackName: "vpc", Plan: &cfn.StackPlan{StackName: "vpc", Status: "DOESN'T EXIST", Action: cfn.PlanCreate}})
+	plan.Add(Result{FlowName: "app", Order: 1, StackName: "db", Plan: &cfn.StackPlan{StackName: "db", Status: "CREATE_COMPLETE", Action: cfn.PlanSkip}})
+
+	t.Log("When summarizing the plan")
+	{
+		s := plan.Summarize()
+		expected := PlanSummary{Create: 1, Update: 1, Skip: 1, Add: 1, Modify: 1, Replacements: 1}
+		if s != expected {
+			t.Fatalf("Expected summary %+v but got %+v", expected, s)
+		}
+	}
+
+	t.Log("When rendering the plan")
+	{
+		var buf bytes.Buffer
+		plan.Render(&buf)
+		out := buf.String()
+
+		expected := []string{
+			"ORDER: 0",
+			"FLOW: network",
+			"+ vpc (DOESN'T EXIST) will be created",
+			"ORDER: 1",
+			"FLOW: app",
+			"~ api (UPDATE_COMPLETE) will be updated",
+			"-/+ Modify",
+			"+ Add",
+			"= db (CREATE_COMPLETE) no changes",
+			"Plan: 1 to create, 1 to update, 0 to delete, 1 unchanged, 0 blocked.",
+			"Resources: 1 to add, 1 to modify, 0 to remove, 1 replacement(s).",
+		}
+		last := -1
+		for _, e := range expected {
+			i := strings.Index(out, e)
+			if i <= last {
+				t.Fatalf("Expected %q to be rendered in order but got:\n%s", e, out)
+			}
+			last = i
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// defaultSinkTimeout bounds the delivery of an event by the command and webhook sinks
+const defaultSinkTimeout = 10 * time.Second
+
+// JSONLinesSink writes every event as a JSON object on its own line
+type JSONLinesSink struct {
+	w io.Writer
+	f *os.File
+}
+
+func NewJSONLinesSink(w io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{w: w}
+}
+
+// NewJSONLinesFileSink appends the events to the file, created if needed
+func NewJSONLinesFileSink(path string) (*JSONLinesSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLinesSink{w: f, f: f}, nil
+}
+
+func (s *JSONLinesSink) Send(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+// Close closes the file of NewJSONLinesFileSink
+func (s *JSONLinesSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+/*
+CommandSink runs a shell command for every event, the event is written as JSON on its stdin
+and its type is exported as CFNC_EVENT_TYPE, e.g. to filter the events in the script.
+*/
+type CommandSink struct {
+	Command string
+	// Timeout bounds every run of the command, defaults to 10s
+	Timeout time.Duration
+}
+
+func (s CommandSink) Send(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sinkTimeout(s.Timeout))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.Command)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Env = append(os.Environ(), "CFNC_EVENT_TYPE="+e.Type)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command %q failed: %w, output: %s", s.Command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// WebhookSink posts every event as JSON to the URL
+type WebhookSink struct {
+	URL string
+	// Client defaults to a client timing out after 10s
+	Client *http.Client
+}
+
+func (s WebhookSink) Send(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultSinkTimeout}
+	}
+
+	res, err := client.Post(s.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", s.URL, res.Status)
+	}
+	return nil
+}
+
+func sinkTimeout(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+	return defaultSinkTimeout
+}
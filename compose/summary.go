@@ -0,0 +1,197 @@
+package compose
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn"
+	"github.com/rbalman/cfn-compose/config"
+)
+
+// Outcomes of the stacks of a compose run
+const (
+	OutcomeCreated     = "created"
+	OutcomeUpdated     = "updated"
+	OutcomeNoChange    = "no-change"
+	OutcomeDeleted     = "deleted"
+	OutcomeSkipped     = "skipped"
+	OutcomePlanned     = "planned"
+	OutcomeFailed      = "failed"
+	OutcomeTimedOut    = "timed-out"
+	OutcomeInterrupted = "interrupted"
+	OutcomeNotStarted  = "not-started"
+)
+
+// outcomes lists the outcomes in the order they are counted
+var outcomes = []string{OutcomeCreated, OutcomeUpdated, OutcomeNoChange, OutcomeDeleted, OutcomeSkipped, OutcomePlanned, OutcomeFailed, OutcomeTimedOut, OutcomeInterrupted, OutcomeNotStarted}
+
+// errNotStarted is returned by the stacks dispatched once the run was already stopped
+var errNotStarted = errors.New("the stack was not started")
+
+// StackSummary is the outcome of a stack once the compose run is over
+type StackSummary struct {
+	FlowName  string
+	Order     int
+	StackName string
+	Outcome   string
+	Duration  time.Duration
+	Error     error
+}
+
+// Summary lists the outcome of every stack of the compose run
+type Summary struct {
+	// DeployMode tells whether the skipped stacks had no change or didn't exist
+	DeployMode bool
+	Stacks     []StackSummary
+}
+
+// outcome classifies the result of a stack, stopped tells whether the run was already stopped when it was received
+func (s Summary) outcome(r Result, stopped bool) string {
+	var terr *cfn.TimeoutError
+	switch {
+	case errors.Is(r.Error, errNotStarted):
+		return OutcomeNotStarted
+	case errors.As(r.Error, &terr):
+		return OutcomeTimedOut
+	case r.Error != nil && (stopped || errors.Is(r.Error, context.Canceled)):
+		return OutcomeInterrupted
+	case r.Error != nil:
+		return OutcomeFailed
+	case r.Plan != nil:
+		return OutcomePlanned
+	}
+
+	switch r.Action {
+	case cfn.PlanCreate:
+		return OutcomeCreated
+	case cfn.PlanUpdate:
+		return OutcomeUpdated
+	case cfn.PlanDelete:
+		return OutcomeDeleted
+	case cfn.PlanSkip:
+		if s.DeployMode {
+			return OutcomeNoChange
+		}
+		return OutcomeSkipped
+	default:
+		return OutcomeSkipped
+	}
+}
+
+func (s *Summary) Add(r Result, stopped bool) {
+	s.Stacks = append(s.Stacks, StackSummary{FlowName: r.FlowName, Order: r.Order, StackName: r.StackName, Outcome: s.outcome(r, stopped), Duration: r.Duration, Error: r.Error})
+}
+
+// AddNotStarted adds the stacks of the graph that were never dispatched
+func (s *Summary) AddNotStarted(graph *config.Graph) {
+	added := make(map[string]bool)
+	for _, stack := range s.Stacks {
+		added[stack.StackName] = true
+	}
+
+	for _, name := range graph.Names() {
+		if node := graph.Nodes[name]; !added[name] {
+			s.Stacks = append(s.Stacks, StackSummary{FlowName: node.FlowName, Order: node.Order, StackName: name, Outcome: OutcomeNotStarted})
+		}
+	}
+}
+
+// Count returns the number of stacks with the given outcome
+func (s Summary) Count(outcome string) int {
+	count := 0
+	for _, stack := range s.Stacks {
+		if stack.Outcome == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+// Failed reports whether a stack didn't complete
+func (s Summary) Failed() bool {
+	return s.Count(OutcomeFailed)+s.Count(OutcomeTimedOut)+s.Count(OutcomeInterrupted)+s.Count(OutcomeNotStarted) > 0
+}
+
+// Completed returns the number of stacks that completed, whatever the action taken
+func (s Summary) Completed() int {
+	completed := 0
+	for _, outcome := range []string{OutcomeCreated, OutcomeUpdated, OutcomeNoChange, OutcomeDeleted, OutcomeSkipped, OutcomePlanned} {
+		completed += s.Count(outcome)
+	}
+	return completed
+}
+
+// AuthFailed reports whether a stack failed because the AWS credentials were rejected
+func (s Summary) AuthFailed() bool {
+	for _, stack := range s.Stacks {
+		if isAuthError(stack.Error) {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts describes the number of stacks per outcome, e.g. "2 created, 1 failed"
+func (s Summary) Counts() string {
+	var counts []string
+	for _, outcome := range outcomes {
+		if n := s.Count(outcome); n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, outcome))
+		}
+	}
+	return strings.Join(counts, ", ")
+}
+
+// Render writes the outcome and duration of every stack, ordered by order, flow and stack name
+func (s Summary) Render(w io.Writer) {
+	sort.SliceStable(s.Stacks, func(i, j int) bool {
+		a, b := s.Stacks[i], s.Stacks[j]
+		if a.Order != b.Order {
+			return a.Order < b.Order
+		}
+		if a.FlowName != b.FlowName {
+			return a.FlowName < b.FlowName
+		}
+		return a.StackName < b.StackName
+	})
+
+	fmt.Fprintf(w, "\nSUMMARY\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "    \tORDER\tFLOW\tSTACK\tRESULT\tDURATION")
+	for _, stack := range s.Stacks {
+		duration := "-"
+		if stack.Outcome != OutcomeNotStarted {
+			duration = stack.Duration.Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "    \t%d\t%s\t%s\t%s\t%s\n", stack.Order, stack.FlowName, stack.StackName, stack.Outcome, duration)
+	}
+	tw.Flush()
+
+	// the reason of a failure is only known from the events of the stack, the waiter error doesn't tell it
+	var causes []string
+	for _, stack := range s.Stacks {
+		if serr := rootCause(stack.Error); serr != nil {
+			causes = append(causes, fmt.Sprintf("    [STACK: %s] [STATUS: %s] %s", stack.StackName, serr.Status, serr.RootCause()))
+		}
+	}
+	if len(causes) > 0 {
+		fmt.Fprintf(w, "\nROOT CAUSES\n%s\n", strings.Join(causes, "\n"))
+	}
+
+	fmt.Fprintf(w, "\nStacks: %s.\n\n", s.Counts())
+}
+
+// rootCause returns the StackError of a failed stack when its failed resource is known
+func rootCause(err error) *cfn.StackError {
+	var serr *cfn.StackError
+	if errors.As(err, &serr) && serr.RootCause() != "" {
+		return serr
+	}
+	return nil
+}
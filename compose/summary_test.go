@@ -0,0 +1,56 @@
+package compose
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rbalman/cfn-compose/cfn"
+)
+
+func TestSummary(t *testing.T) {
+	t.Log("When the results of a stopped run are summarized")
+	{
+		s := Summary{DeployMode: true}
+		s.Add(Result{FlowName: "App", StackName: "api", Action: cfn.PlanCreate, Duration: 90 * time.Second}, false)
+		s.Add(Result{FlowName: "App", StackName: "cache", Action: cfn.PlanSkip}, false)
+		s.Add(Result{FlowName: "App", StackName: "db", Error: &cfn.StackError{StackName: "db", Operation: "UpdateStack", Status: "UPDATE_ROLLBACK_COMPLETE", LogicalResourceID: "Instance", ResourceType: "AWS::RDS::DBInstance", ResourceStatus: "UPDATE_FAILED", Reason: "Invalid storage size", Err: errors.New("waiter error")}}, false)
+		s.Add(Result{FlowName: "App", StackName: "queue", Error: fmt.Errorf("wait: %w", &cfn.TimeoutError{StackName: "queue", Err: context.DeadlineExceeded})}, false)
+		s.Add(Result{FlowName: "Web", StackName: "cdn", Error: errors.New("waiter context canceled")}, true)
+		s.Add(Result{FlowName: "Web", StackName: "dns", Error: fmt.Errorf("Skipping... %w: %s", errNotStarted, context.Canceled)}, true)
+
+		expected := map[string]string{"api": OutcomeCreated, "cache": OutcomeNoChange, "db": OutcomeFailed, "queue": OutcomeTimedOut, "cdn": OutcomeInterrupted, "dns": OutcomeNotStarted}
+		for _, stack := range s.Stacks {
+			if stack.Outcome != expected[stack.StackName] {
+				t.Fatalf("Expected %s outcome to be %s but got %s", stack.StackName, expected[stack.StackName], stack.Outcome)
+			}
+		}
+
+		var out bytes.Buffer
+		s.Render(&out)
+		if !strings.Contains(out.String(), "1 created, 1 no-change, 1 failed, 1 timed-out, 1 interrupted, 1 not-started") || !strings.Contains(out.String(), "1m30s") {
+			t.Fatalf("Expected the outcomes to be counted but got:\n%s", out.String())
+		}
+		if !strings.Contains(out.String(), "ROOT CAUSES\n    [STACK: db] [STATUS: UPDATE_ROLLBACK_COMPLETE] Instance (AWS::RDS::DBInstance) UPDATE_FAILED: Invalid storage size") {
+			t.Fatalf("Expected the root cause of the failed stack but got:\n%s", out.String())
+		}
+		if !s.Failed() {
+			t.Fatal("Expected the summary to be failed")
+		}
+	}
+
+	t.Log("When the stacks of a destroy are summarized")
+	{
+		s := Summary{}
+		s.Add(Result{FlowName: "App", StackName: "api", Action: cfn.PlanDelete}, false)
+		s.Add(Result{FlowName: "App", StackName: "db", Action: cfn.PlanSkip}, false)
+
+		if counts := s.Counts(); counts != "1 deleted, 1 skipped" || s.Failed() {
+			t.Fatalf("Expected the destroy to be summarized but got %s", counts)
+		}
+	}
+}
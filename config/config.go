@@ -1,10 +1,11 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"github.com/rbalman/cfn-compose/cfn"
-	"os"
 	"path/filepath"
+	"time"
 )
 
 const composeDir string = ".cfn-compose"
@@ -15,6 +16,8 @@ var stackCountLimit int = 50
 
 type ComposeConfig struct {
 	Description string            `yaml:"Description"`
+	Concurrency int               `yaml:"Concurrency,omitempty"`
+	Retry       cfn.RetryPolicy   `yaml:"Retry,omitempty"`
 	Flows       map[string]Flow   `yaml:"Flows"`
 	Vars        map[string]string `yaml:"Vars"`
 }
@@ -24,12 +27,15 @@ type Flow struct {
 	Description string      `yaml:"Description,omitempty"`
 	Stacks      []cfn.Stack `yaml:"Stacks"`
 	Order       int         `yaml:"Order"`
+	// Timeout bounds the flow from the start of its first stack, e.g. 45m
+	Timeout time.Duration `yaml:"Timeout,omitempty"`
 }
 
 /*
 Flow is valid when all of the below conditions are true:
 - When Stack counts is <= stackCountLimit
 - order property should be a valid unsigned integer
+- Timeout is not negative, 0 means no limit
 - When all stacks are valid
 */
 func (j *Flow) Validate(name string) error {
@@ -41,6 +47,10 @@ func (j *Flow) Validate(name string) error {
 		return fmt.Errorf("Flow Order should be within 0-100 range, found: %d", j.Order)
 	}
 
+	if j.Timeout < 0 {
+		return fmt.Errorf("Flow Timeout should be >= 0, found: %s", j.Timeout)
+	}
+
 	for i, stack := range j.Stacks {
 		err := stack.Validate(i)
 		if err != nil {
@@ -53,8 +63,13 @@ func (j *Flow) Validate(name string) error {
 /*
 ComposeConfig is valid when all of the below conditions are true:
 - When Flow counts is <= flowCoutLimit
+- When Concurrency is not negative, 0 means no limit
+- When the Retry policy is valid
 - When all flows are valid
 - When all stacks inside the flows are valid
+- When stack names are unique across flows
+- When depends_on only refers to stacks defined in the compose config
+- When the stack dependencies don't form a cycle
 */
 func (c *ComposeConfig) Validate() error {
 	if len(c.Flows) > flowCountLimit {
@@ -65,25 +80,147 @@ func (c *ComposeConfig) Validate() error {
 		return fmt.Errorf("Flow count is %d, compose config should have at least one flow", len(c.Flows))
 	}
 
+	if c.Concurrency < 0 {
+		return fmt.Errorf("Concurrency should be >= 0, found: %d", c.Concurrency)
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return err
+	}
+
 	for jname, flow := range c.Flows {
 		if err := flow.Validate(jname); err != nil {
 			return fmt.Errorf("[Flow: %s] Error: %s", jname, err.Error())
 		}
 	}
 
-	return nil
+	stackFlows := make(map[string]string)
+	for jname, flow := range c.Flows {
+		for _, stack := range flow.Stacks {
+			if other, ok := stackFlows[stack.StackName]; ok {
+				return fmt.Errorf("[Flow: %s] Error: stack_name %s is already used in Flow: %s", jname, stack.StackName, other)
+			}
+			stackFlows[stack.StackName] = jname
+		}
+	}
+
+	for jname, flow := range c.Flows {
+		for _, stack := range flow.Stacks {
+			for _, dep := range stack.DependsOn {
+				if _, ok := stackFlows[dep]; !ok {
+					return fmt.Errorf("[Flow: %s] Error: stack %s depends on %s which is not defined in the compose config", jname, stack.StackName, dep)
+				}
+			}
+		}
+	}
+
+	return NewGraph(c.Flows).CheckCycles()
 }
 
+/*
+GetComposeConfig parses the compose file, the relative template and parameters files are resolved from the directory
+of the compose file and the parameters files are merged into the parameters of their stack.
+*/
 func GetComposeConfig(configFile string) (ComposeConfig, error) {
-	var cc ComposeConfig
-	dir := filepath.Dir(configFile)
-	file := filepath.Base(configFile)
-	os.Chdir(dir)
+	return LoadComposeConfig(configFile, "")
+}
 
-	cc, err := parse(file)
+// LoadComposeConfig is GetComposeConfig also saving the rendered compose file into stateDir, nothing is saved when empty
+func LoadComposeConfig(configFile, stateDir string) (ComposeConfig, error) {
+	dir := filepath.Dir(configFile)
+	cc, err := parse(configFile, stateDir)
 	if err != nil {
-		fmt.Printf("Failed while fetching compose file: %s\n", err.Error())
+		return cc, err
+	}
+
+	for _, flow := range cc.Flows {
+		for i, stack := range flow.Stacks {
+			if stack.TemplateFile != "" && !filepath.IsAbs(stack.TemplateFile) {
+				flow.Stacks[i].TemplateFile = filepath.Join(dir, stack.TemplateFile)
+			}
+			if stack.ParametersFile != "" && !filepath.IsAbs(stack.ParametersFile) {
+				flow.Stacks[i].ParametersFile = filepath.Join(dir, stack.ParametersFile)
+			}
+		}
 	}
 
+	err = cc.LoadParametersFiles()
 	return cc, err
 }
+
+// StateDir returns the .cfn-compose directory next to the compose file, where the CLI saves the rendered compose file
+func StateDir(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), composeDir)
+}
+
+// LoadParametersFiles merges the parameters_file of every stack into its parameters, the flows of c are replaced by copies
+func (c *ComposeConfig) LoadParametersFiles() error {
+	flows := make(map[string]Flow, len(c.Flows))
+	for name, flow := range c.Flows {
+		stacks := make([]cfn.Stack, len(flow.Stacks))
+		for i, stack := range flow.Stacks {
+			var err error
+			if stacks[i], err = stack.LoadParametersFile(); err != nil {
+				return fmt.Errorf("[Flow: %s] Error: %w", name, err)
+			}
+		}
+		flow.Stacks = stacks
+		flows[name] = flow
+	}
+	c.Flows = flows
+	return nil
+}
+
+/*
+ValidateParameters checks the parameters of the stacks against their local template, see cfn.Stack.ValidateParameters.
+All the problems are reported at once in a *cfn.TemplateError, the stacks using a template_url aren't checked.
+*/
+func (c *ComposeConfig) ValidateParameters() error {
+	if problems := c.templateProblems(false); len(problems) > 0 {
+		return &cfn.TemplateError{Problems: problems}
+	}
+	return nil
+}
+
+// LintTemplates checks the parameters of the stacks and lints every local template once, see cfn.Template.Lint
+func (c *ComposeConfig) LintTemplates() *cfn.TemplateError {
+	return &cfn.TemplateError{Problems: c.templateProblems(true)}
+}
+
+// templateProblems returns the problems of the local templates in the order of the stacks
+func (c *ComposeConfig) templateProblems(lint bool) []cfn.TemplateProblem {
+	var problems []cfn.TemplateProblem
+	templates := make(map[string]*cfn.Template)
+	graph := NewGraph(c.Flows)
+	for _, name := range graph.Names() {
+		stack := graph.Nodes[name].Stack
+		if stack.TemplateFile == "" {
+			continue
+		}
+
+		t, ok := templates[stack.TemplateFile]
+		if !ok {
+			var err error
+			if t, err = cfn.ParseTemplate(stack.TemplateFile); err != nil {
+				problems = append(problems, templateProblem(stack, err))
+			} else if lint {
+				problems = append(problems, t.Lint()...)
+			}
+			templates[stack.TemplateFile] = t
+		}
+		if t != nil {
+			problems = append(problems, stack.ValidateParameters(t)...)
+		}
+	}
+	return problems
+}
+
+// templateProblem returns the problem of a template that couldn't be read or parsed
+func templateProblem(stack cfn.Stack, err error) cfn.TemplateProblem {
+	var problem cfn.TemplateProblem
+	if !errors.As(err, &problem) {
+		problem = cfn.TemplateProblem{File: stack.TemplateFile, Message: err.Error()}
+	}
+	problem.StackName = stack.StackName
+	return problem
+}
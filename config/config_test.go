@@ -1,10 +1,15 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"github.com/rbalman/cfn-compose/cfn"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestValidateComposeConfig(t *testing.T) {
@@ -29,6 +34,19 @@ func TestValidateComposeConfig(t *testing.T) {
 		}
 	}
 
+	t.Log("When concurrency is negative")
+	{
+		cc := ComposeConfig{
+			Concurrency: -1,
+			Flows:       generateFlowsMap(1, 1),
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil", err)
+		}
+	}
+
 	t.Log("When flow order is negative")
 	{
 		cc := ComposeConfig{
@@ -79,6 +97,43 @@ func TestValidateComposeConfig(t *testing.T) {
 		}
 	}
 
+	t.Log("When flow timeout is negative")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {
+					Timeout: -time.Minute,
+					Stacks: []cfn.Stack{
+						{StackName: "stack1", TemplateFile: "stack1.yml"},
+					},
+				},
+			},
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil", err)
+		}
+	}
+
+	t.Log("When stack timeout is negative")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {
+					Stacks: []cfn.Stack{
+						{StackName: "stack1", TemplateFile: "stack1.yml", TimeoutInMinutes: -1},
+					},
+				},
+			},
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil", err)
+		}
+	}
+
 	t.Log("When one or more flow doesn't have any stack")
 	{
 		cc := ComposeConfig{
@@ -221,6 +276,149 @@ func TestValidateComposeConfig(t *testing.T) {
 	}
 }
 
+func TestValidateStackDependencies(t *testing.T) {
+	t.Log("When stack names are duplicated across flows")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {Stacks: []cfn.Stack{{StackName: "s1", TemplateFile: "s1.yml"}}},
+				"flow2": {Stacks: []cfn.Stack{{StackName: "s1", TemplateFile: "s1.yml"}}},
+			},
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil")
+		}
+	}
+
+	t.Log("When depends_on refers to an unknown stack")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {Stacks: []cfn.Stack{{StackName: "s1", TemplateFile: "s1.yml", DependsOn: []string{"unknown"}}}},
+			},
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil")
+		}
+	}
+
+	t.Log("When depends_on forms a cycle across flows")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {Stacks: []cfn.Stack{{StackName: "s1", TemplateFile: "s1.yml", DependsOn: []string{"s2"}}}},
+				"flow2": {Stacks: []cfn.Stack{{StackName: "s2", TemplateFile: "s2.yml", DependsOn: []string{"s1"}}}},
+			},
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil")
+		}
+	}
+
+	t.Log("When depends_on conflicts with the flow order")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {Order: 0, Stacks: []cfn.Stack{{StackName: "s1", TemplateFile: "s1.yml"}, {StackName: "s2", TemplateFile: "s2.yml", DependsOn: []string{"s3"}}}},
+				"flow2": {Order: 1, Stacks: []cfn.Stack{{StackName: "s3", TemplateFile: "s3.yml"}}},
+			},
+		}
+
+		err := cc.Validate()
+		if err == nil {
+			t.Fatal("Validation should return error but found nil")
+		}
+	}
+
+	t.Log("When depends_on is valid")
+	{
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"flow1": {Order: 0, Stacks: []cfn.Stack{{StackName: "s1", TemplateFile: "s1.yml"}, {StackName: "s2", TemplateFile: "s2.yml"}}},
+				"flow2": {Order: 1, Stacks: []cfn.Stack{{StackName: "s3", TemplateFile: "s3.yml", DependsOn: []string{"s1"}}}},
+			},
+		}
+
+		err := cc.Validate()
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Validation should return nil but found error: %s", err))
+		}
+	}
+}
+
+func TestGetComposeConfig(t *testing.T) {
+	t.Log("When the compose file is in another directory")
+	{
+		dir := t.TempDir()
+		compose := "Flows:\n  App:\n    Stacks:\n    - template_file: templates/app.yml\n      stack_name: app\n    - template_file: /templates/db.yml\n      stack_name: db\n"
+		if err := os.WriteFile(filepath.Join(dir, "cfn-compose.yml"), []byte(compose), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wd, _ := os.Getwd()
+
+		cc, err := GetComposeConfig(filepath.Join(dir, "cfn-compose.yml"))
+		if err != nil {
+			t.Fatalf("GetComposeConfig should return nil but found error: %s", err)
+		}
+		stacks := cc.Flows["App"].Stacks
+		if stacks[0].TemplateFile != filepath.Join(dir, "templates/app.yml") || stacks[1].TemplateFile != "/templates/db.yml" {
+			t.Fatalf("Expected the relative template to be resolved from %s but got %s and %s", dir, stacks[0].TemplateFile, stacks[1].TemplateFile)
+		}
+		if now, _ := os.Getwd(); now != wd {
+			t.Fatalf("Expected the working directory to stay %s but got %s", wd, now)
+		}
+		if _, err := os.Stat(StateDir(filepath.Join(dir, "cfn-compose.yml"))); !os.IsNotExist(err) {
+			t.Fatalf("Expected the rendered compose file not to be saved but got %v", err)
+		}
+	}
+
+	t.Log("When the rendered compose file is saved")
+	{
+		dir := t.TempDir()
+		compose := "Vars:\n  ENV_NAME: test\nFlows:\n  App:\n    Stacks:\n    - template_file: app.yml\n      stack_name: app-{{ .ENV_NAME }}\n"
+		configFile := filepath.Join(dir, "cfn-compose.yml")
+		if err := os.WriteFile(configFile, []byte(compose), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadComposeConfig(configFile, StateDir(configFile)); err != nil {
+			t.Fatalf("LoadComposeConfig should return nil but found error: %s", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, ".cfn-compose", "compose.yml"))
+		if err != nil || !strings.Contains(string(data), "stack_name: app-test") {
+			t.Fatalf("Expected the rendered compose file to be saved but got %q, %v", data, err)
+		}
+	}
+
+	t.Log("When a stack has a relative parameters file")
+	{
+		dir := t.TempDir()
+		compose := "Flows:\n  App:\n    Stacks:\n    - template_file: app.yml\n      stack_name: app\n      parameters_file: params/app.json\n      parameters:\n        Env: prod\n"
+		params := `[{"ParameterKey": "Env", "ParameterValue": "dev"}, {"ParameterKey": "Size", "ParameterValue": "2"}]`
+		os.MkdirAll(filepath.Join(dir, "params"), 0755)
+		if err := os.WriteFile(filepath.Join(dir, "params", "app.json"), []byte(params), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cfn-compose.yml"), []byte(compose), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cc, err := GetComposeConfig(filepath.Join(dir, "cfn-compose.yml"))
+		if err != nil {
+			t.Fatalf("GetComposeConfig should return nil but found error: %s", err)
+		}
+		if p := cc.Flows["App"].Stacks[0].Parameters; p["Env"] != "prod" || p["Size"] != "2" {
+			t.Fatalf("Expected the parameters file to be merged under the inline parameters but got %v", p)
+		}
+	}
+}
+
 func generateFlowsMap(flowCount, stackCount int) map[string]Flow {
 	m := make(map[string]Flow)
 	var stacks []cfn.Stack
@@ -240,3 +438,61 @@ func generateFlowsMap(flowCount, stackCount int) map[string]Flow {
 
 	return m
 }
+
+func TestValidateParameters(t *testing.T) {
+	t.Log("When the stacks have problems in several templates")
+	{
+		dir := t.TempDir()
+		app := filepath.Join(dir, "app.yml")
+		broken := filepath.Join(dir, "broken.yml")
+		os.WriteFile(app, []byte("Parameters:\n  Env:\n    Type: String\nResources: {}\n"), 0644)
+		os.WriteFile(broken, []byte("Resources: [\n"), 0644)
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"App": {Order: 1, Stacks: []cfn.Stack{
+					{StackName: "api", TemplateFile: app},
+					{StackName: "web", TemplateFile: app, Parameters: map[string]string{"Env": "dev", "Port": "80"}},
+					{StackName: "remote", TemplateURL: "https://bucket.s3.amazonaws.com/template.yml", Parameters: map[string]string{"Any": "value"}},
+				}},
+				"Data": {Order: 0, Stacks: []cfn.Stack{{StackName: "db", TemplateFile: broken}}},
+			},
+		}
+
+		err := cc.ValidateParameters()
+		var terr *cfn.TemplateError
+		if !errors.As(err, &terr) || len(terr.Problems) != 3 {
+			t.Fatalf("Expected the 3 problems to be reported at once but got %v", err)
+		}
+		if terr.Problems[0].StackName != "db" || terr.Problems[1].StackName != "api" || terr.Problems[2].StackName != "web" {
+			t.Fatalf("Expected the problems in the order of the stacks but got %v", err)
+		}
+	}
+}
+
+func TestLintTemplates(t *testing.T) {
+	t.Log("When a template with problems is shared by several stacks")
+	{
+		dir := t.TempDir()
+		shared := filepath.Join(dir, "shared.yml")
+		os.WriteFile(shared, []byte("Parameters:\n  Env:\n    Type: String\n    Default: dev\nResources:\n  Queue:\n    Type: AWS::SQS::Queue\n    DependsOn: Topic\n"), 0644)
+		cc := ComposeConfig{
+			Flows: map[string]Flow{
+				"App": {Stacks: []cfn.Stack{
+					{StackName: "api", TemplateFile: shared},
+					{StackName: "web", TemplateFile: shared, Parameters: map[string]string{"Envv": "dev"}},
+				}},
+			},
+		}
+
+		report := cc.LintTemplates()
+		if !report.HasErrors() || len(report.Problems) != 3 {
+			t.Fatalf("Expected the template to be linted once along with the parameters of both stacks but got %v", report)
+		}
+		if p := report.Problems[1]; !p.Warning || p.Line != 2 {
+			t.Fatalf("Expected the unused parameter warning on line 2 but got %v", report)
+		}
+		if p := report.Problems[2]; p.StackName != "web" || p.Warning {
+			t.Fatalf("Expected the unknown parameter of web but got %v", report)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rbalman/cfn-compose/cfn"
+)
+
+// Node is a stack of the compose configuration along with the flow it belongs to
+type Node struct {
+	FlowName  string
+	Order     int
+	Stack     cfn.Stack
+	DependsOn []string
+}
+
+/*
+Graph holds the stacks of the selected flows and the dependencies between them.
+A stack depends on:
+- the previous stack of the same flow
+- the stacks listed in its depends_on property
+- the stacks whose outputs are referenced in its parameters
+- all the stacks of the flows with a lower Order, when it is the first stack of its flow without depends_on
+
+Dependencies on stacks that are not part of the selected flows are ignored.
+*/
+type Graph struct {
+	Nodes      map[string]*Node
+	dependents map[string][]string
+	names      []string
+}
+
+func NewGraph(flows map[string]Flow) *Graph {
+	g := Graph{
+		Nodes:      make(map[string]*Node),
+		dependents: make(map[string][]string),
+	}
+
+	flowNames := make([]string, 0, len(flows))
+	for name := range flows {
+		flowNames = append(flowNames, name)
+	}
+	sort.Slice(flowNames, func(i, j int) bool {
+		fi, fj := flows[flowNames[i]], flows[flowNames[j]]
+		if fi.Order != fj.Order {
+			return fi.Order < fj.Order
+		}
+		return flowNames[i] < flowNames[j]
+	})
+
+	for _, name := range flowNames {
+		for _, stack := range flows[name].Stacks {
+			g.Nodes[stack.StackName] = &Node{FlowName: name, Order: flows[name].Order, Stack: stack}
+			g.names = append(g.names, stack.StackName)
+		}
+	}
+
+	for _, name := range flowNames {
+		flow := flows[name]
+		for i, stack := range flow.Stacks {
+			node := g.Nodes[stack.StackName]
+			if i > 0 {
+				g.addEdge(node, flow.Stacks[i-1].StackName)
+			}
+
+			for _, dep := range stack.DependsOn {
+				g.addEdge(node, dep)
+			}
+
+			for _, ref := range stack.OutputRefs() {
+				g.addEdge(node, ref.StackName)
+			}
+
+			if i == 0 && len(stack.DependsOn) == 0 {
+				for _, other := range g.names {
+					if g.Nodes[other].Order < flow.Order {
+						g.addEdge(node, other)
+					}
+				}
+			}
+		}
+	}
+
+	return &g
+}
+
+func (g *Graph) addEdge(node *Node, dep string) {
+	if _, ok := g.Nodes[dep]; !ok {
+		return
+	}
+
+	for _, d := range node.DependsOn {
+		if d == dep {
+			return
+		}
+	}
+
+	node.DependsOn = append(node.DependsOn, dep)
+	g.dependents[dep] = append(g.dependents[dep], node.Stack.StackName)
+}
+
+// Names returns the stack names sorted by flow order, flow name and the position inside the flow
+func (g *Graph) Names() []string {
+	return append([]string{}, g.names...)
+}
+
+// Dependents returns the names of the stacks that depend on the given stack
+func (g *Graph) Dependents(name string) []string {
+	return g.dependents[name]
+}
+
+// Reverse returns the graph used for deletion, where every stack waits for its dependents instead
+func (g *Graph) Reverse() *Graph {
+	r := Graph{
+		Nodes:      make(map[string]*Node),
+		dependents: make(map[string][]string),
+	}
+
+	for i := len(g.names) - 1; i >= 0; i-- {
+		name := g.names[i]
+		node := *g.Nodes[name]
+		node.DependsOn = append([]string{}, g.dependents[name]...)
+		r.Nodes[name] = &node
+		r.names = append(r.names, name)
+	}
+
+	for _, name := range r.names {
+		for _, dep := range r.Nodes[name].DependsOn {
+			r.dependents[dep] = append(r.dependents[dep], name)
+		}
+	}
+
+	return &r
+}
+
+// CheckCycles returns an error describing the first dependency cycle found in the graph
+func (g *Graph) CheckCycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range g.Nodes[name].DependsOn {
+			switch state[dep] {
+			case visiting:
+				start := 0
+				for i, p := range path {
+					if p == dep {
+						start = i
+					}
+				}
+				cycle := append(append([]string{}, path[start:]...), dep)
+				return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+			case unvisited:
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range g.names {
+		if state[name] == unvisited {
+			if err := visit(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
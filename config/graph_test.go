@@ -0,0 +1,89 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rbalman/cfn-compose/cfn"
+)
+
+func TestNewGraph(t *testing.T) {
+	flows := map[string]Flow{
+		"network": {Order: 0, Stacks: []cfn.Stack{{StackName: "vpc"}, {StackName: "sg"}}},
+		"queue":   {Order: 0, Stacks: []cfn.Stack{{StackName: "sqs"}}},
+		"app":     {Order: 1, Stacks: []cfn.Stack{{StackName: "ec2"}, {StackName: "alarm"}}},
+		"db":      {Order: 1, Stacks: []cfn.Stack{{StackName: "rds", DependsOn: []string{"vpc"}}}},
+	}
+
+	g := NewGraph(flows)
+
+	t.Log("When sorting the stack names")
+	{
+		expected := []string{"vpc", "sg", "sqs", "ec2", "alarm", "rds"}
+		if !reflect.DeepEqual(g.Names(), expected) {
+			t.Fatalf("Expected names %v but got %v", expected, g.Names())
+		}
+	}
+
+	t.Log("When the stack is not the first one of the flow")
+	{
+		expected := []string{"vpc"}
+		if !reflect.DeepEqual(g.Nodes["sg"].DependsOn, expected) {
+			t.Fatalf("Expected sg dependencies %v but got %v", expected, g.Nodes["sg"].DependsOn)
+		}
+	}
+
+	t.Log("When the first stack of a flow has no depends_on")
+	{
+		expected := []string{"vpc", "sg", "sqs"}
+		if !reflect.DeepEqual(g.Nodes["ec2"].DependsOn, expected) {
+			t.Fatalf("Expected ec2 dependencies %v but got %v", expected, g.Nodes["ec2"].DependsOn)
+		}
+	}
+
+	t.Log("When the first stack of a flow has depends_on")
+	{
+		expected := []string{"vpc"}
+		if !reflect.DeepEqual(g.Nodes["rds"].DependsOn, expected) {
+			t.Fatalf("Expected rds dependencies %v but got %v", expected, g.Nodes["rds"].DependsOn)
+		}
+	}
+
+	t.Log("When the graph is reversed")
+	{
+		r := g.Reverse()
+		expected := []string{"rds", "alarm", "ec2", "sqs", "sg", "vpc"}
+		if !reflect.DeepEqual(r.Names(), expected) {
+			t.Fatalf("Expected names %v but got %v", expected, r.Names())
+		}
+
+		expected = []string{"sg", "ec2", "rds"}
+		if !reflect.DeepEqual(r.Nodes["vpc"].DependsOn, expected) {
+			t.Fatalf("Expected vpc dependencies %v but got %v", expected, r.Nodes["vpc"].DependsOn)
+		}
+
+		if len(r.Nodes["alarm"].DependsOn) != 0 {
+			t.Fatalf("Expected alarm to have no dependencies but got %v", r.Nodes["alarm"].DependsOn)
+		}
+
+		if err := r.CheckCycles(); err != nil {
+			t.Fatalf("Expected no cycles but got %s", err)
+		}
+	}
+
+	t.Log("When a dependency is not part of the selected flows")
+	{
+		g := NewGraph(map[string]Flow{"db": flows["db"]})
+		if len(g.Nodes["rds"].DependsOn) != 0 {
+			t.Fatalf("Expected rds to have no dependencies but got %v", g.Nodes["rds"].DependsOn)
+		}
+	}
+
+	t.Log("When a stack depends on itself")
+	{
+		g := NewGraph(map[string]Flow{"db": {Stacks: []cfn.Stack{{StackName: "rds", DependsOn: []string{"rds"}}}}})
+		if err := g.CheckCycles(); err == nil {
+			t.Fatal("Expected a cycle error but found nil")
+		}
+	}
+}
@@ -1,23 +1,18 @@
 package config
 
 import (
-	"errors"
-	"log"
+	"bytes"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 
+	"github.com/rbalman/cfn-compose/cfn"
 	"gopkg.in/yaml.v2"
 )
 
-func parse(file string) (ComposeConfig, error) {
-	if _, err := os.Stat(composeDir); errors.Is(err, os.ErrNotExist) {
-		err := os.Mkdir(composeDir, os.ModePerm)
-		if err != nil {
-			log.Println(err)
-		}
-	}
-
+// parse renders the Vars of the compose file and parses it, the rendered compose file is saved into stateDir unless empty
+func parse(file, stateDir string) (ComposeConfig, error) {
 	var cc ComposeConfig
 	data, err := os.ReadFile(file)
 	if err != nil {
@@ -29,24 +24,33 @@ func parse(file string) (ComposeConfig, error) {
 		return cc, err
 	}
 
-	t, err := template.New("ComposeConfigTemplate").Parse(string(data))
-	if err != nil {
-		return cc, err
+	funcs := template.FuncMap{
+		// output references are resolved at deploy time, so the reference is written back as is
+		"output": func(stackName, outputKey string) string {
+			return cfn.OutputRef{StackName: stackName, OutputKey: outputKey}.String()
+		},
 	}
 
-	compose_state_file, err := os.Create(composeDir + "/" + composeTemplate)
+	t, err := template.New("ComposeConfigTemplate").Funcs(funcs).Parse(string(data))
 	if err != nil {
 		return cc, err
 	}
-	defer compose_state_file.Close()
-	t.Execute(compose_state_file, vars)
 
-	composeData, err := os.ReadFile(composeDir + "/" + composeTemplate)
-	if err != nil {
+	var composeData bytes.Buffer
+	if err := t.Execute(&composeData, vars); err != nil {
 		return cc, err
 	}
 
-	err = yaml.Unmarshal([]byte(composeData), &cc)
+	if stateDir != "" {
+		if err := os.MkdirAll(stateDir, os.ModePerm); err != nil {
+			return cc, err
+		}
+		if err := os.WriteFile(filepath.Join(stateDir, composeTemplate), composeData.Bytes(), 0644); err != nil {
+			return cc, err
+		}
+	}
+
+	err = yaml.Unmarshal(composeData.Bytes(), &cc)
 	if err != nil {
 		return cc, err
 	}
@@ -66,6 +70,11 @@ func extractVars(data []byte) (map[string]string, error) {
 		return vars.Vmap, err
 	}
 
+	// Vars is optional
+	if vars.Vmap == nil {
+		vars.Vmap = make(map[string]string)
+	}
+
 	err = overrideWithEnvs(vars.Vmap)
 	return vars.Vmap, err
 }
@@ -4,20 +4,19 @@ import (
 	"fmt"
 	"os"
 
-	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
-	"github.com/rbalman/cfn-compose/logger"
-	"time"
 )
 
-func GetAWSSession() (*session.Session, error) {
-	region := os.Getenv("AWS_REGION")
+// GetAWSSession returns a session for the given profile and region, both fall back to the environment and the shared config when empty
+func GetAWSSession(profile, region string) (*session.Session, error) {
 	return session.NewSessionWithOptions(session.Options{
+		Profile: profile,
 		Config: aws.Config{
 			Region: &region,
+			// Retries are handled by cfn.RetryPolicy
 			Retryer: client.DefaultRetryer{ //https://github.com/aws/aws-sdk-go/tree/main/example/aws/request/customRetryer
 				NumMaxRetries: 0,
 			},
@@ -39,6 +38,12 @@ func PrintCallerIdentity(identity *sts.GetCallerIdentityOutput) {
 	fmt.Printf("User: %s\n", *identity.UserId)
 }
 
+// IsTerminal reports whether the file is a terminal rather than a pipe or a regular file
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func ReadTemplate(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -46,15 +51,3 @@ func ReadTemplate(path string) (string, error) {
 	}
 	return string(data), nil
 }
-
-func Loader(ctx context.Context, ch chan bool) {
-	for {
-		select {
-		case <-ch:
-			return
-		default:
-			time.Sleep(15000 * time.Millisecond)
-			logger.Log.InfoCtxf(ctx, "→ →..")
-		}
-	}
-}
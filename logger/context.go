@@ -0,0 +1,22 @@
+package logger
+
+import "context"
+
+// ContextKey is the type of the context keys read by the logger
+type ContextKey string
+
+// Context keys of the logs of a stack, the values are strings except for OrderKey which is an int
+const (
+	OrderKey ContextKey = "order"
+	FlowKey  ContextKey = "flow"
+	StackKey ContextKey = "stack"
+	// EventKey is the type of the run event being logged, e.g. stack_resource
+	EventKey ContextKey = "event"
+	// LoggerKey holds the Logger of the run, see WithLogger
+	LoggerKey ContextKey = "logger"
+)
+
+// WithEvent returns a context whose logs are tagged with the event type
+func WithEvent(ctx context.Context, event string) context.Context {
+	return context.WithValue(ctx, EventKey, event)
+}
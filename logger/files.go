@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// flowColors are the colors given to the flows, Red is left to the failures
+var flowColors = []string{Blue, Magenta, Cyan, Green, Yellow}
+
+// FlowColor returns the color of the flow, derived from its name so that it stays the same from one run to another
+func FlowColor(flow string) string {
+	h := fnv.New32a()
+	h.Write([]byte(flow))
+	return flowColors[h.Sum32()%uint32(len(flowColors))]
+}
+
+/*
+Files writes the logs of a run into its directory: run.log holds all of them,
+flows/<flow>.log and stacks/<stack>.log the logs of every flow and stack for later inspection.
+*/
+type Files struct {
+	Dir   string
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// OpenFiles creates the run directory and its run.log
+func OpenFiles(dir string) (*Files, error) {
+	for _, d := range []string{dir, filepath.Join(dir, "flows"), filepath.Join(dir, "stacks")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &Files{Dir: dir, files: make(map[string]*os.File)}
+	if _, err := f.file("run.log"); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// write appends the line to run.log and to the files of the flow and stack of the context
+func (f *Files) write(ctx context.Context, line string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := []string{"run.log"}
+	if flow, ok := ctx.Value(FlowKey).(string); ok {
+		names = append(names, filepath.Join("flows", fileName(flow)))
+	}
+	if stack, ok := ctx.Value(StackKey).(string); ok {
+		names = append(names, filepath.Join("stacks", fileName(stack)))
+	}
+
+	for _, name := range names {
+		// a log file that can't be written doesn't stop the run, the console still has the logs
+		if file, err := f.file(name); err == nil {
+			file.WriteString(line)
+		}
+	}
+}
+
+// file returns the opened file of the run directory, opening it when needed
+func (f *Files) file(name string) (*os.File, error) {
+	if file, ok := f.files[name]; ok {
+		return file, nil
+	}
+	file, err := os.OpenFile(filepath.Join(f.Dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.files[name] = file
+	return file, nil
+}
+
+// Close closes the files of the run
+func (f *Files) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var err error
+	for name, file := range f.files {
+		if cerr := file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(f.files, name)
+	}
+	return err
+}
+
+// fileName turns a flow or stack name into a file name
+func fileName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_", " ", "_").Replace(name) + ".log"
+}
+
+func withNewline(str string) string {
+	if strings.HasSuffix(str, "\n") {
+		return str
+	}
+	return str + "\n"
+}
@@ -0,0 +1,41 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// levelNames are the levels written in the JSON lines
+var levelNames = map[int32]string{DEBUG: "DEBUG", INFO: "INFO", WARN: "WARN", ERROR: "ERROR"}
+
+// jsonLine formats the message as a JSON object along with its level, time and the context of the stack
+func jsonLine(ctx context.Context, level int32, message string) string {
+	entry := struct {
+		Level   string `json:"level"`
+		Time    string `json:"timestamp"`
+		Order   *int   `json:"order,omitempty"`
+		Flow    string `json:"flow,omitempty"`
+		Stack   string `json:"stack,omitempty"`
+		Event   string `json:"event,omitempty"`
+		Message string `json:"message"`
+	}{
+		Level:   levelNames[level],
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Message: strings.TrimSpace(message),
+	}
+
+	if order, ok := ctx.Value(OrderKey).(int); ok {
+		entry.Order = &order
+	}
+	entry.Flow, _ = ctx.Value(FlowKey).(string)
+	entry.Stack, _ = ctx.Value(StackKey).(string)
+	entry.Event, _ = ctx.Value(EventKey).(string)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return `{"level":"ERROR","message":"the log line couldn't be written as JSON"}`
+	}
+	return string(data)
+}
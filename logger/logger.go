@@ -3,20 +3,22 @@ package logger
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 var Reset = "\033[0m"
 var Red = "\033[31m"
 var Green = "\033[32m"
 var Yellow = "\033[33m"
-
-// var Blue = "\033[34m"
-// var Magenta = "\033[35m"
-// var Cyan = "\033[36m"
+var Blue = "\033[34m"
+var Magenta = "\033[35m"
+var Cyan = "\033[36m"
 
 const (
 	DEBUG int32 = 0
@@ -25,17 +27,39 @@ const (
 	ERROR int32 = 8
 )
 
+// Log formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
 type Logger struct {
 	Debug    *log.Logger
 	Info     *log.Logger
 	Warn     *log.Logger
 	Error    *log.Logger
 	LogLevel int32
+	// Format is text or json, one JSON object per line
+	Format string
+	// Color prefixes the text logs of every flow with its own color, for terminals
+	Color bool
+	files *Files
 }
 
 var Log Logger
 
 func Start(logLevel int32) {
+	Log = New(os.Stdout, os.Stderr, logLevel)
+}
+
+// New returns a text logger writing to out, and the errors to errOut, from the given level
+func New(out, errOut io.Writer, logLevel int32) Logger {
+	return NewWithFormat(out, errOut, logLevel, FormatText)
+}
+
+// NewWithFormat returns a logger writing in the given format, the JSON lines carry their own level so they have no prefix
+func NewWithFormat(out, errOut io.Writer, logLevel int32, format string) Logger {
+	l := Logger{Format: format}
 	debugHandle := ioutil.Discard
 	infoHandle := ioutil.Discard
 	warnHandle := ioutil.Discard
@@ -44,29 +68,29 @@ func Start(logLevel int32) {
 	switch logLevel {
 
 	case DEBUG:
-		debugHandle = os.Stdout
-		infoHandle = os.Stdout
-		warnHandle = os.Stdout
-		errorHandle = os.Stderr
+		debugHandle = out
+		infoHandle = out
+		warnHandle = out
+		errorHandle = errOut
 
 	case INFO:
-		infoHandle = os.Stdout
-		warnHandle = os.Stdout
-		errorHandle = os.Stderr
+		infoHandle = out
+		warnHandle = out
+		errorHandle = errOut
 
 	case WARN:
-		warnHandle = os.Stdout
-		errorHandle = os.Stderr
+		warnHandle = out
+		errorHandle = errOut
 
 	case ERROR:
-		warnHandle = os.Stdout
-		errorHandle = os.Stderr
+		warnHandle = out
+		errorHandle = errOut
 
 	// Defaults to INFO
 	default:
-		infoHandle = os.Stdout
-		warnHandle = os.Stdout
-		errorHandle = os.Stderr
+		infoHandle = out
+		warnHandle = out
+		errorHandle = errOut
 
 	}
 
@@ -75,12 +99,90 @@ func Start(logLevel int32) {
 	// Log.Warn = log.New(warnHandle, "[WARN] ", log.Ldate|log.Ltime|log.Lshortfile)
 	// Log.Error = log.New(errorHandle, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
 
-	Log.Debug = log.New(debugHandle, "[DEBUG] ", 0)
-	Log.Info = log.New(infoHandle, "[INFO] ", 0)
-	Log.Warn = log.New(warnHandle, "[WARN] ", log.Lshortfile)
-	Log.Error = log.New(errorHandle, "[ERROR] ", log.Lshortfile)
+	if format == FormatJSON {
+		l.Debug = log.New(debugHandle, "", 0)
+		l.Info = log.New(infoHandle, "", 0)
+		l.Warn = log.New(warnHandle, "", 0)
+		l.Error = log.New(errorHandle, "", 0)
+		l.LogLevel = logLevel
+		return l
+	}
+
+	l.Debug = log.New(debugHandle, "[DEBUG] ", 0)
+	l.Info = log.New(infoHandle, "[INFO] ", 0)
+	l.Warn = log.New(warnHandle, "[WARN] ", log.Lshortfile)
+	l.Error = log.New(errorHandle, "[ERROR] ", log.Lshortfile)
+
+	l.LogLevel = logLevel
+	return l
+}
+
+// WithLogger returns a context whose Ctxf logs go to l instead of the logger they are called on
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, LoggerKey, l)
+}
 
-	atomic.StoreInt32(&Log.LogLevel, logLevel)
+// FromContext returns the logger of the context, Log when there is none
+func FromContext(ctx context.Context) Logger {
+	return Log.withContext(ctx)
+}
+
+// withContext returns the logger of the context if any, so that the programs embedding cfn-compose keep their logs
+func (l Logger) withContext(ctx context.Context) Logger {
+	if cl, ok := ctx.Value(LoggerKey).(Logger); ok {
+		return cl
+	}
+	return l
+}
+
+// output writes the log line at the given level, the loggers of a zero Logger discard it.
+// The files of the run get the lines from LogLevel on, whatever the console writers.
+func (l Logger) output(ctx context.Context, level int32, str string) {
+	var lg *log.Logger
+	switch level {
+	case DEBUG:
+		lg = l.Debug
+	case INFO:
+		lg = l.Info
+	case WARN:
+		lg = l.Warn
+	default:
+		lg = l.Error
+	}
+	if lg == nil {
+		return
+	}
+
+	if l.Format == FormatJSON {
+		line := jsonLine(ctx, level, str)
+		if l.files != nil && level >= l.LogLevel {
+			l.files.write(ctx, line+"\n")
+		}
+		lg.Output(3, line)
+		return
+	}
+
+	ctxStr := getContextString(ctx)
+	if l.files != nil && level >= l.LogLevel {
+		l.files.write(ctx, fmt.Sprintf("%s %s%s%s", time.Now().Format(time.RFC3339), lg.Prefix(), ctxStr, withNewline(str)))
+	}
+	if flow, ok := ctx.Value(FlowKey).(string); ok && l.Color && ctxStr != "" {
+		ctxStr = FlowColor(flow) + strings.TrimSuffix(ctxStr, " ") + Reset + " "
+	}
+	lg.Output(3, ctxStr+str)
+}
+
+// WithFiles returns a logger also writing its logs to the files of a run
+func (l Logger) WithFiles(files *Files) Logger {
+	l.files = files
+	return l
+}
+
+// WithOutput returns a logger writing to out, and the errors to errOut, keeping the level, format, colors and files
+func (l Logger) WithOutput(out, errOut io.Writer) Logger {
+	n := NewWithFormat(out, errOut, l.LogLevel, l.Format)
+	n.Color, n.files = l.Color, l.files
+	return n
 }
 
 func GetLogLevel(level string) int32 {
@@ -101,6 +203,11 @@ func StartWithLabel(level string) {
 	Start(ll)
 }
 
+// StartWithFormat starts the global logger at the given level label in the text or json format
+func StartWithFormat(level, format string) {
+	Log = NewWithFormat(os.Stdout, os.Stderr, GetLogLevel(level), format)
+}
+
 // LogLevel returns the configured logging level.
 func LogLevel() int32 {
 	return atomic.LoadInt32(&Log.LogLevel)
@@ -108,144 +215,76 @@ func LogLevel() int32 {
 
 func (l Logger) Debugf(format string, params ...interface{}) {
 	str := fmt.Sprintf(format, params...)
-	l.Debug.Output(2, str)
+	l.output(context.Background(), DEBUG, str)
 }
 
 func (l Logger) Debugln(params ...interface{}) {
 	str := fmt.Sprintf("%s", params...)
-	l.Debug.Output(2, str)
+	l.output(context.Background(), DEBUG, str)
 }
 
 func (l Logger) DebugCtxf(ctx context.Context, format string, params ...interface{}) {
-	ctxStr := getContextString(ctx)
 	str := fmt.Sprintf(format, params...)
-	l.Debug.Output(2, ctxStr+str)
+	l.withContext(ctx).output(ctx, DEBUG, str)
 }
 
 func (l Logger) Infof(format string, params ...interface{}) {
 	str := fmt.Sprintf(format, params...)
-	l.Info.Output(2, str)
+	l.output(context.Background(), INFO, str)
 }
 
 func (l Logger) Infoln(params ...interface{}) {
 	str := fmt.Sprintf("%s", params...)
-	l.Info.Output(2, str)
+	l.output(context.Background(), INFO, str)
 }
 
 func (l Logger) InfoCtxf(ctx context.Context, format string, params ...interface{}) {
-	ctxStr := getContextString(ctx)
 	str := fmt.Sprintf(format, params...)
-	l.Info.Output(2, ctxStr+str)
+	l.withContext(ctx).output(ctx, INFO, str)
 }
 
 func (l Logger) Warnf(format string, params ...interface{}) {
 	str := fmt.Sprintf(format, params...)
-	l.Warn.Output(2, str)
+	l.output(context.Background(), WARN, str)
 }
 
 func (l Logger) Warnln(params ...interface{}) {
 	str := fmt.Sprintf("%s", params...)
-	l.Warn.Output(2, str)
+	l.output(context.Background(), WARN, str)
 }
 
 func (l Logger) WarnCtxf(ctx context.Context, format string, params ...interface{}) {
-	ctxStr := getContextString(ctx)
 	str := fmt.Sprintf(format, params...)
-	l.Warn.Output(2, ctxStr+str)
+	l.withContext(ctx).output(ctx, WARN, str)
 }
 
 func (l Logger) Errorf(format string, params ...interface{}) {
 	str := fmt.Sprintf(format, params...)
-	l.Error.Output(2, str)
+	l.output(context.Background(), ERROR, str)
 }
 
 func (l Logger) Errorln(params ...interface{}) {
 	str := fmt.Sprintf("%s", params...)
-	l.Error.Output(2, str)
+	l.output(context.Background(), ERROR, str)
 }
 
 func (l Logger) ErrorCtxf(ctx context.Context, format string, params ...interface{}) {
-	ctxStr := getContextString(ctx)
 	str := fmt.Sprintf(format, params...)
-	l.Error.Output(2, ctxStr+str)
+	l.withContext(ctx).output(ctx, ERROR, str)
 }
 
 func getContextString(ctx context.Context) (ctxStr string) {
-	if order, ok := ctx.Value("order").(int); ok {
+	if order, ok := ctx.Value(OrderKey).(int); ok {
 		ctxStr += fmt.Sprintf("[ORDER: %d] ", order)
 	}
 
-	if flow, ok := ctx.Value("flow").(string); ok {
+	if flow, ok := ctx.Value(FlowKey).(string); ok {
 		ctxStr += fmt.Sprintf("[FLOW: %s] ", flow)
 	}
 
-	if stack, ok := ctx.Value("stack").(string); ok {
+	if stack, ok := ctx.Value(StackKey).(string); ok {
 		ctxStr += fmt.Sprintf("[STACK: %s] ", stack)
 	}
 
 	return
 }
-
-// func (l *Logger) ColorPrint(ctx context.Context, lg string) {
-// 	if color, ok := ctx.Value("logColor").(string); ok {
-// 		lg = fmt.Sprintf("%s%s%s", color, lg, Reset)
-// 	}
-// 	fmt.Printf("%s", lg)
-// }
-
-// func (l *Logger) ColorPrintf(ctx context.Context, lg string, params ...interface{}) {
-// 	str := formatString(lg, params...)
-// 	l.ColorPrint(ctx, str)
-// }
-
-// func (l *Logger) Info(lg string) string {
-// 	lg = fmt.Sprintf("%sℹ %s%s", Blue, lg, Reset)
-// 	fmt.Printf("%s\n", lg)
-// 	return lg
-// }
-
-// func (l *Logger) Infof(lg string, params ...interface{}) string {
-// 	str := formatString(lg, params...)
-// 	return l.Info(str)
-// }
-
-// func (l *Logger) Warn(lg string) string {
-// 	lg = fmt.Sprintf("%s⚠ %s%s", Yellow, lg, Reset)
-// 	fmt.Printf("%s\n", lg)
-// 	return lg
-// }
-
-// func (l *Logger) Warnf(lg string, params ...interface{}) string {
-// 	str := formatString(lg, params...)
-// 	return l.Warn(str)
-// }
-
-// func (l *Logger) Error(lg string) string {
-// 	lg = fmt.Sprintf("%s✖️ %s %s", Red, lg, Reset)
-// 	fmt.Printf("%s\n", lg)
-// 	return lg
-// }
-// func (l *Logger) Errorf(lg string, params ...interface{}) string {
-// 	str := formatString(lg, params...)
-// 	return l.Error(str)
-// }
-
-// func (l *Logger) Success(lg string) string {
-// 	lg = fmt.Sprintf("%s✔ %s %s", Green, lg, Reset)
-// 	fmt.Printf("%s\n", lg)
-// 	return lg
-// }
-
-// func (l *Logger) Successf(lg string, params ...interface{}) string {
-// 	str := formatString(lg, params...)
-// 	return l.Success(str)
-// }
-// func (l *Logger) Print(lg string) string {
-// 	lg = fmt.Sprintf("%s %s %s", Reset, lg, Reset)
-// 	fmt.Printf("%s\n", lg)
-// 	return lg
-// }
-// func (l *Logger) Printf(lg string, params ...interface{}) string {
-// 	str := formatString(lg, params...)
-// 	return l.Print(str)
-// }
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerFormat(t *testing.T) {
+	ctx := context.WithValue(context.Background(), OrderKey, 1)
+	ctx = context.WithValue(ctx, FlowKey, "App")
+	ctx = context.WithValue(ctx, StackKey, "api")
+
+	t.Log("When the logs are written as JSON")
+	{
+		var out, errOut bytes.Buffer
+		l := NewWithFormat(&out, &errOut, INFO, FormatJSON)
+		l.InfoCtxf(WithEvent(ctx, "stack_resource"), "Instance (AWS::EC2::Instance) %s\n", "CREATE_COMPLETE")
+		l.Errorf("Compose failed\n")
+		l.Debugf("hidden\n")
+
+		var entry map[string]interface{}
+		if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected a JSON object but got %s: %s", out.String(), err)
+		}
+		expected := map[string]interface{}{"level": "INFO", "order": 1.0, "flow": "App", "stack": "api", "event": "stack_resource", "message": "Instance (AWS::EC2::Instance) CREATE_COMPLETE"}
+		for key, value := range expected {
+			if entry[key] != value {
+				t.Fatalf("Expected %s to be %v but got %v", key, value, entry[key])
+			}
+		}
+		if entry["timestamp"] == "" {
+			t.Fatal("Expected the entry to have a timestamp")
+		}
+
+		var errEntry map[string]interface{}
+		if err := json.Unmarshal(errOut.Bytes(), &errEntry); err != nil || errEntry["level"] != "ERROR" || errEntry["stack"] != nil {
+			t.Fatalf("Expected an ERROR entry without stack on errOut but got %s", errOut.String())
+		}
+		if strings.Contains(out.String(), "hidden") {
+			t.Fatal("Expected the DEBUG logs to be discarded at INFO level")
+		}
+	}
+
+	t.Log("When the logs are written as text")
+	{
+		var out bytes.Buffer
+		l := New(&out, &out, INFO)
+		l.InfoCtxf(ctx, "Create Complete...\n")
+
+		if out.String() != "[INFO] [ORDER: 1] [FLOW: App] [STACK: api] Create Complete...\n" {
+			t.Fatalf("Expected the context to prefix the message but got %q", out.String())
+		}
+	}
+
+	t.Log("When the context carries another logger")
+	{
+		var out, other bytes.Buffer
+		l := New(&out, &out, INFO)
+		l.InfoCtxf(WithLogger(ctx, New(&other, &other, INFO)), "Create Complete...\n")
+
+		if out.Len() != 0 || !strings.Contains(other.String(), "Create Complete") {
+			t.Fatalf("Expected the logger of the context to be used but got %q and %q", out.String(), other.String())
+		}
+	}
+
+	t.Log("When the flows are colored")
+	{
+		var out bytes.Buffer
+		l := New(&out, &out, INFO)
+		l.Color = true
+		l.InfoCtxf(ctx, "Create Complete...\n")
+		l.Infof("Successfully Completed!!\n")
+
+		expected := "[INFO] " + FlowColor("App") + "[ORDER: 1] [FLOW: App] [STACK: api]" + Reset + " Create Complete...\n[INFO] Successfully Completed!!\n"
+		if out.String() != expected || FlowColor("App") != FlowColor("App") {
+			t.Fatalf("Expected the context of the flow to be colored but got %q", out.String())
+		}
+	}
+
+	t.Log("When the logs are also written to the files of the run")
+	{
+		files, err := OpenFiles(filepath.Join(t.TempDir(), "run"))
+		if err != nil {
+			t.Fatalf("OpenFiles should return nil but found error: %s", err)
+		}
+		l := New(io.Discard, io.Discard, INFO).WithFiles(files)
+		l.Color = true
+		l.InfoCtxf(ctx, "Create Complete...\n")
+		l.InfoCtxf(context.WithValue(ctx, StackKey, "db"), "Update Completed.\n")
+		l.Debugf("hidden\n")
+		l.Infof("Successfully Completed!!\n")
+		files.Close()
+
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(files.Dir, name))
+			return string(data)
+		}
+		if run := read("run.log"); strings.Count(run, "\n") != 3 || strings.Contains(run, "hidden") || strings.Contains(run, Reset) {
+			t.Fatalf("Expected run.log to hold the 3 plain lines but got %q", run)
+		}
+		if flow := read("flows/App.log"); strings.Count(flow, "\n") != 2 {
+			t.Fatalf("Expected flows/App.log to hold the 2 lines of the flow but got %q", flow)
+		}
+		if stack := read("stacks/api.log"); !strings.HasSuffix(stack, "[INFO] [ORDER: 1] [FLOW: App] [STACK: api] Create Complete...\n") || strings.Contains(stack, "db") {
+			t.Fatalf("Expected stacks/api.log to hold the line of the stack but got %q", stack)
+		}
+	}
+
+	t.Log("When the logger is the zero value")
+	{
+		var l Logger
+		l.InfoCtxf(ctx, "discarded\n")
+	}
+}